@@ -1,16 +1,18 @@
 package main
 
 import (
+	"os"
 	"time"
 	"strings"
 	"strconv"
 	"net/http"
 	"encoding/json"
+	"path/filepath"
 )
 
 type Tr_RequestStruct struct {
 	Method string      `json:"method"`
-	Args   interface{} `json:"arguments"`
+	Args   interface{} `json:"arguments,omitempty"`
 }
 type Tr_ResponseStruct struct {
 	Result string      `json:"result"`
@@ -21,20 +23,41 @@ type Tr_TorrentsResponseStruct struct {
 }
 type Tr_GetStruct struct {
 	Field []string `json:"fields"`
+	IDs   []string `json:"ids,omitempty"`
+}
+type Tr_TorrentSetStruct struct {
+	IDs           []string `json:"ids"`
+	UploadLimit   int64    `json:"uploadLimit"`
+	UploadLimited bool     `json:"uploadLimited"`
 }
 type Tr_SessionSetStruct struct {
 	BlocklistEnabled bool   `json:"blocklist-enabled"`
 	BlocklistSize    int    `json:"blocklist-size"`
 	BlocklistURL     string `json:"blocklist-url"`
 }
+type Tr_SettingsStruct struct {
+	RPCEnabled      bool   `json:"rpc-enabled"`
+	RPCBindAddress  string `json:"rpc-bind-address"`
+	RPCPort         int    `json:"rpc-port"`
+	RPCURL          string `json:"rpc-url"`
+	RPCAuthRequired bool   `json:"rpc-authentication-required"`
+	RPCUsername     string `json:"rpc-username"`
+}
 type Tr_TorrentsStruct struct {
 	Torrents []Tr_TorrentStruct `json:"torrents"`
 }
 type Tr_TorrentStruct struct {
-	InfoHash     string          `json:"hashString"`
-	TotalSize    int64           `json:"totalSize"`
-	Private      bool            `json:"private"`
-	Peers        []Tr_PeerStruct `json:"peers"`
+	InfoHash      string             `json:"hashString"`
+	TotalSize     int64              `json:"totalSize"`
+	Private       bool               `json:"isPrivate"`
+	Labels        []string           `json:"labels"`
+	Trackers      []Tr_TrackerStruct `json:"trackers"`
+	Peers         []Tr_PeerStruct    `json:"peers"`
+	UploadLimit   int64              `json:"uploadLimit"`
+	UploadLimited bool               `json:"uploadLimited"`
+}
+type Tr_TrackerStruct struct {
+	Announce string `json:"announce"`
 }
 type Tr_PeerStruct struct {
 	IP          string  `json:"address"`
@@ -45,14 +68,21 @@ type Tr_PeerStruct struct {
 	DlSpeed     int64   `json:"rateToClient"`
 	UpSpeed     int64   `json:"rateToPeer"`
 }
+type Tr_PeerEstimateStruct struct {
+	Timestamp     int64
+	DlSpeed       int64
+	UpSpeed       int64
+	Downloaded    int64
+	Uploaded      int64
+	StartProgress float64
+	Progress      float64
+}
 
 var Tr_csrfToken = ""
 var Tr_ipfilterStr = ""
 var Tr_jsonHeader = map[string]string { "Content-Type": "application.json" }
+var Tr_peerEstimateMap = make(map[string]Tr_PeerEstimateStruct)
 
-func Tr_InitClient() {
-	go StartServer()
-}
 func Tr_ProcessHTTP(w http.ResponseWriter, r *http.Request) bool {
 	if strings.SplitN(r.RequestURI, "?", 2)[0] == "/ipfilter.dat" {
 		w.WriteHeader(200)
@@ -63,8 +93,116 @@ func Tr_ProcessHTTP(w http.ResponseWriter, r *http.Request) bool {
 
 	return false
 }
+func Tr_GetConfigPaths() []string {
+	trConfigFilenames := []string {}
+	for _, clientConfigPath := range config.ClientConfigPaths {
+		if strings.HasSuffix(strings.ToLower(clientConfigPath), ".json") {
+			trConfigFilenames = append(trConfigFilenames, clientConfigPath)
+		}
+	}
+
+	if transmissionHome := os.Getenv("TRANSMISSION_HOME"); transmissionHome != "" {
+		trConfigFilenames = append(trConfigFilenames, filepath.Join(transmissionHome, "settings.json"))
+	}
+
+	userHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		Log("Debug-GetConfigPath", GetLangText("Error-Debug-GetConfigPath_GetUserHomeDir"), true, err.Error())
+		return trConfigFilenames
+	}
+
+	if IsUnix(userHomeDir) {
+		trConfigFilenames = append(trConfigFilenames,
+			userHomeDir + "/.config/transmission-daemon/settings.json",
+			userHomeDir + "/.config/transmission/settings.json",
+			"/var/lib/transmission-daemon/.config/transmission-daemon/settings.json",
+			"/var/lib/transmission/.config/transmission-daemon/settings.json",
+			"/etc/transmission-daemon/settings.json",
+			"/config/settings.json",
+		)
+	} else {
+		userConfigDir, err := os.UserConfigDir()
+		if err != nil {
+			Log("Debug-GetConfigPath", GetLangText("Error-Debug-GetConfigPath_GetUserConfigDir"), true, err.Error())
+			return trConfigFilenames
+		}
+		trConfigFilenames = append(trConfigFilenames, userConfigDir + "\\transmission-daemon\\settings.json", userConfigDir + "\\transmission\\settings.json")
+	}
+
+	return trConfigFilenames
+}
+func Tr_GetConfig() []byte {
+	for _, trConfigFilename := range Tr_GetConfigPaths() {
+		_, err := os.Stat(trConfigFilename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				Log("GetConfig", GetLangText("Error-GetConfig_LoadConfigMeta"), true, err.Error())
+			}
+			continue
+		}
+
+		Log("GetConfig", GetLangText("GetConfig_UseConfig"), true, trConfigFilename)
+
+		trConfigFile, err := os.ReadFile(trConfigFilename)
+		if err != nil {
+			Log("GetConfig", GetLangText("Error-GetConfig_LoadConfig"), true, err.Error())
+			continue
+		}
+
+		return trConfigFile
+	}
+
+	return []byte {}
+}
 func Tr_SetURL() bool {
-	return false
+	trConfigFile := Tr_GetConfig()
+	if len(trConfigFile) < 1 {
+		return false
+	}
+
+	// 默认值与 Transmission 一致, 配置文件中未出现的项将保持默认值.
+	trSettings := Tr_SettingsStruct { RPCEnabled: true, RPCBindAddress: "0.0.0.0", RPCPort: 9091, RPCURL: "/transmission/" }
+	if err := json.Unmarshal(trConfigFile, &trSettings); err != nil {
+		Log("SetURL", GetLangText("Error-Parse"), true, err.Error())
+		return false
+	}
+
+	trAddress := StrTrim(trSettings.RPCBindAddress)
+	if trAddress == "" || trAddress == "*" || trAddress == "0.0.0.0" {
+		trAddress = "127.0.0.1"
+	} else if trAddress == "::" || trAddress == "::1" {
+		trAddress = "[::1]"
+	} else if IsIPv6(trAddress) {
+		trAddress = "[" + trAddress + "]"
+	}
+
+	if !trSettings.RPCEnabled {
+		Log("SetURL", GetLangText("Abandon-SetURL"), true, trSettings.RPCEnabled, trAddress)
+		return false
+	}
+
+	rpcURL := trSettings.RPCURL
+	if rpcURL == "" {
+		rpcURL = "/transmission/"
+	}
+	if !strings.HasPrefix(rpcURL, "/") {
+		rpcURL = "/" + rpcURL
+	}
+	if !strings.HasSuffix(rpcURL, "/") {
+		rpcURL += "/"
+	}
+
+	config.ClientURL = "http://" + trAddress + ":" + strconv.Itoa(trSettings.RPCPort) + rpcURL + "rpc"
+
+	// Transmission 配置文件中仅保存密码哈希, 因此密码仍需手动设置.
+	if trSettings.RPCAuthRequired {
+		config.ClientUsername = trSettings.RPCUsername
+	} else {
+		config.ClientUsername = ""
+	}
+
+	Log("SetURL", GetLangText("Success-SetURL"), true, trSettings.RPCEnabled, config.ClientURL, config.ClientUsername)
+	return true
 }
 func Tr_DetectVersion() bool {
 	detectJSON, err := json.Marshal(Tr_RequestStruct { Method: "session-get", Args: Tr_GetStruct { Field: []string { "version" } } })
@@ -73,8 +211,18 @@ func Tr_DetectVersion() bool {
 		return false
 	}
 
-	detectStatusCode, _ := Submit(config.ClientURL, string(detectJSON), false, false, &Tr_jsonHeader)
-	return (detectStatusCode == 200 || detectStatusCode == 409)
+	_, err = DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(detectJSON), Header: &Tr_jsonHeader })
+	if err == nil {
+		return true
+	}
+
+	// 未携带 CSRF Token 时 Transmission 会返回 409, 同样可以说明客户端类型.
+	if errType, _, ok := GetRequestErrorType(err); ok && errType == RequestError_CSRF {
+		return true
+	}
+
+	Log("Debug-DetectVersion", "%s", false, err.Error())
+	return false
 }
 func Tr_Login() bool {
 	// Transmission 通过 Basic Auth 进行认证, 因此实际处理 CSRF 请求以避免 409 响应.
@@ -84,10 +232,14 @@ func Tr_Login() bool {
 		return false
 	}
 
-	Submit(config.ClientURL, string(loginJSON), false, true, nil)
+	_, err = DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(loginJSON), WithAuth: true })
 
-	if Tr_csrfToken == "" {
-		Log("Login", GetLangText("Error-Login"), true)
+	if Tr_csrfToken == "" || err != nil {
+		if err != nil {
+			Log("Login", GetLangText("Error-Login") + ": %s", true, err.Error())
+		} else {
+			Log("Login", GetLangText("Error-Login"), true)
+		}
 		return false
 	}
 
@@ -98,20 +250,20 @@ func Tr_SetCSRFToken(csrfToken string) {
 	Log("SetCSRFToken", GetLangText("Success-SetCSRFToken"), true, csrfToken)
 }
 func Tr_FetchTorrents() *Tr_TorrentsStruct {
-	loginJSON, err := json.Marshal(Tr_RequestStruct { Method: "torrent-get", Args: Tr_GetStruct { Field: []string { "hashString", "totalSize", "isPrivate", "peers" } } })
+	loginJSON, err := json.Marshal(Tr_RequestStruct { Method: "torrent-get", Args: Tr_GetStruct { Field: []string { "hashString", "totalSize", "isPrivate", "labels", "trackers", "peers" } } })
 	if err != nil {
 		Log("FetchTorrents", GetLangText("Error-GenJSON"), true, err.Error())
 		return nil
 	}
 
-	_, torrentsResponseBody := Submit(config.ClientURL, string(loginJSON), true, true, &Tr_jsonHeader)
-	if torrentsResponseBody == nil {
-		Log("FetchTorrents", GetLangText("Error"), true)
+	torrentsHTTPResponse, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(loginJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("FetchTorrents", "%s", true, err.Error())
 		return nil
 	}
 
 	var torrentsResponse Tr_TorrentsResponseStruct
-	if err := json.Unmarshal(torrentsResponseBody, &torrentsResponse); err != nil {
+	if err := json.Unmarshal(torrentsHTTPResponse.Body, &torrentsResponse); err != nil {
 		Log("FetchTorrents", GetLangText("Error-Parse"), true, err.Error())
 		return nil
 	}
@@ -123,7 +275,104 @@ func Tr_FetchTorrents() *Tr_TorrentsStruct {
 
 	return &torrentsResponse.Args
 }
+func Tr_EstimatePeer(torrentInfoHash string, torrentTotalSize int64, peer Tr_PeerStruct) (int64, int64) {
+	// Transmission 并不提供 Peer 的 Downloaded 及 Uploaded, 因此按轮询间隔对 rateToClient/rateToPeer 积分 (梯形法) 进行估算.
+	// Peer 的进度增量来自本客户端向其上传的数据, 不能计入 Downloaded, 仅用于日志.
+	peerKey := torrentInfoHash + "|" + peer.IP + ":" + strconv.Itoa(peer.Port)
+
+	peerEstimate, exist := Tr_peerEstimateMap[peerKey]
+	if !exist {
+		peerEstimate = Tr_PeerEstimateStruct { Timestamp: currentTimestamp, StartProgress: peer.Progress }
+	} else if elapsed := (currentTimestamp - peerEstimate.Timestamp); elapsed > 0 {
+		peerEstimate.Downloaded += ((peerEstimate.DlSpeed + peer.DlSpeed) / 2 * elapsed)
+		peerEstimate.Uploaded += ((peerEstimate.UpSpeed + peer.UpSpeed) / 2 * elapsed)
+		peerEstimate.Timestamp = currentTimestamp
+	}
+
+	peerEstimate.DlSpeed = peer.DlSpeed
+	peerEstimate.UpSpeed = peer.UpSpeed
+	peerEstimate.Progress = peer.Progress
+	Tr_peerEstimateMap[peerKey] = peerEstimate
+
+	if config.Debug_CheckPeer {
+		progressDelta := (peerEstimate.Progress - peerEstimate.StartProgress)
+		Log("Debug-EstimatePeer", "%s (Downloaded: %.2f MB, Uploaded: %.2f MB, ProgressDelta: %.2f%% (%.2f MB))", false, peerKey, (float64(peerEstimate.Downloaded) / 1024 / 1024), (float64(peerEstimate.Uploaded) / 1024 / 1024), (progressDelta * 100), (progressDelta * float64(torrentTotalSize) / 1024 / 1024))
+	}
 
+	return peerEstimate.Downloaded, peerEstimate.Uploaded
+}
+func Tr_CleanPeerEstimate() {
+	// 超过 3 个周期未出现的 Peer 视为已断开, 重新连接后将重新开始估算.
+	expireTimestamp := (currentTimestamp - int64(config.Interval) * 3)
+	for peerKey, peerEstimate := range Tr_peerEstimateMap {
+		if peerEstimate.Timestamp < expireTimestamp {
+			delete(Tr_peerEstimateMap, peerKey)
+		}
+	}
+}
+func Tr_GetTorrentUploadLimit(infoHash string) (int64, bool) {
+	torrentGetJSON, err := json.Marshal(Tr_RequestStruct { Method: "torrent-get", Args: Tr_GetStruct { Field: []string { "hashString", "uploadLimit", "uploadLimited" }, IDs: []string { infoHash } } })
+	if err != nil {
+		Log("GetTorrentUploadLimit", GetLangText("Error-GenJSON"), true, err.Error())
+		return 0, false
+	}
+
+	torrentGetHTTPResponse, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(torrentGetJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("GetTorrentUploadLimit", "%s", true, err.Error())
+		return 0, false
+	}
+
+	var torrentGetResponse Tr_TorrentsResponseStruct
+	if err := json.Unmarshal(torrentGetHTTPResponse.Body, &torrentGetResponse); err != nil {
+		Log("GetTorrentUploadLimit", GetLangText("Error-Parse"), true, err.Error())
+		return 0, false
+	}
+
+	if torrentGetResponse.Result != "success" || len(torrentGetResponse.Args.Torrents) <= 0 {
+		Log("GetTorrentUploadLimit", GetLangText("Error-Parse"), true, torrentGetResponse.Result)
+		return 0, false
+	}
+
+	// Transmission 以 KB/s 为单位, 且使用单独的 uploadLimited 表示是否限速.
+	torrentInfo := torrentGetResponse.Args.Torrents[0]
+	if !torrentInfo.UploadLimited {
+		return -1, true
+	}
+
+	return (torrentInfo.UploadLimit * 1024), true
+}
+func Tr_SetTorrentUploadLimit(infoHash string, uploadLimit int64) bool {
+	torrentSet := Tr_TorrentSetStruct { IDs: []string { infoHash }, UploadLimit: (uploadLimit / 1024), UploadLimited: (uploadLimit > 0) }
+	if torrentSet.UploadLimited && torrentSet.UploadLimit < 1 {
+		torrentSet.UploadLimit = 1
+	}
+
+	torrentSetJSON, err := json.Marshal(Tr_RequestStruct { Method: "torrent-set", Args: torrentSet })
+	if err != nil {
+		Log("SetTorrentUploadLimit", GetLangText("Error-GenJSON"), true, err.Error())
+		return false
+	}
+
+	torrentSetHTTPResponse, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(torrentSetJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("SetTorrentUploadLimit", "%s", true, err.Error())
+		return false
+	}
+
+	var torrentSetResponse Tr_ResponseStruct
+	if err := json.Unmarshal(torrentSetHTTPResponse.Body, &torrentSetResponse); err != nil {
+		Log("SetTorrentUploadLimit", GetLangText("Error-Parse"), true, err.Error())
+		return false
+	}
+
+	if torrentSetResponse.Result != "success" {
+		Log("SetTorrentUploadLimit", GetLangText("Error-Parse"), true, torrentSetResponse.Result)
+		return false
+	}
+
+	return true
+}
 func Tr_RestartTorrentByMap(blockPeerMap map[string]BlockPeerInfoStruct) {
 	peerInfoHashes := []string {}
 	for _, peerInfo := range blockPeerMap {
@@ -140,8 +389,8 @@ func Tr_RestartTorrentByMap(blockPeerMap map[string]BlockPeerInfoStruct) {
 		return
 	}
 
-	stopStatusCode, _ := Submit(config.ClientURL, string(stopJSON), true, true, &Tr_jsonHeader)
-	if stopStatusCode != 200 {
+	_, err = DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(stopJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
 		Log("RestartTorrentByMap", GetLangText("Error-RestartTorrentByMap_Stop"), true, err.Error())
 		return
 	}
@@ -155,8 +404,8 @@ func Tr_RestartTorrentByMap(blockPeerMap map[string]BlockPeerInfoStruct) {
 		return
 	}
 
-	startStatusCode, _ := Submit(config.ClientURL, string(startJSON), true, true, &Tr_jsonHeader)
-	if startStatusCode != 200 {
+	_, err = DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(startJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
 		Log("RestartTorrentByMap", GetLangText("Error-RestartTorrentByMap_Start"), true, err.Error())
 		return
 	}
@@ -182,14 +431,14 @@ func Tr_SubmitBlockPeer(blockPeerMap map[string]BlockPeerInfoStruct) bool {
 		return false
 	}
 
-	_, sessionResponseBody := Submit(config.ClientURL, string(sessionSetJSON), true, true, &Tr_jsonHeader)
-	if sessionResponseBody == nil {
-		Log("SubmitBlockPeer", GetLangText("Error"), true)
+	sessionHTTPResponse, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(sessionSetJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("SubmitBlockPeer", "%s", true, err.Error())
 		return false
 	}
 
 	var sessionResponse Tr_ResponseStruct
-	if err := json.Unmarshal(sessionResponseBody, &sessionResponse); err != nil {
+	if err := json.Unmarshal(sessionHTTPResponse.Body, &sessionResponse); err != nil {
 		Log("SubmitBlockPeer", GetLangText("Error-Parse"), true, err.Error())
 		return false
 	}
@@ -205,14 +454,14 @@ func Tr_SubmitBlockPeer(blockPeerMap map[string]BlockPeerInfoStruct) bool {
 		return false
 	}
 
-	_, blocklistUpdateResponseBody := Submit(config.ClientURL, string(blocklistUpdateJSON), true, true, &Tr_jsonHeader)
-	if blocklistUpdateResponseBody == nil {
-		Log("SubmitBlockPeer", GetLangText("Error"), true)
+	blocklistUpdateHTTPResponse, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL, Body: string(blocklistUpdateJSON), Header: &Tr_jsonHeader, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("SubmitBlockPeer", "%s", true, err.Error())
 		return false
 	}
 
 	var blocklistUpdateResponse Tr_ResponseStruct
-	if err := json.Unmarshal(blocklistUpdateResponseBody, &blocklistUpdateResponse); err != nil {
+	if err := json.Unmarshal(blocklistUpdateHTTPResponse.Body, &blocklistUpdateResponse); err != nil {
 		Log("SubmitBlockPeer", GetLangText("Error-Parse"), true, err.Error())
 		return false
 	}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTr_EstimatePeer(t *testing.T) {
+	lastPeerEstimateMap := Tr_peerEstimateMap
+	lastTimestamp := currentTimestamp
+	Tr_peerEstimateMap = make(map[string]Tr_PeerEstimateStruct)
+	currentTimestamp = 1700000000
+	defer func() {
+		Tr_peerEstimateMap = lastPeerEstimateMap
+		currentTimestamp = lastTimestamp
+	}()
+
+	torrentInfoHash := "0123456789abcdef0123456789abcdef01234567"
+	torrentTotalSize := int64(1024 * 1024 * 1024)
+
+	// 仅向 Peer 上传: 其进度增长, 但 Downloaded 应保持为 0.
+	peer := Tr_PeerStruct { IP: "203.0.113.7", Port: 6881, Progress: 0.1, DlSpeed: 0, UpSpeed: 1048576 }
+	Tr_EstimatePeer(torrentInfoHash, torrentTotalSize, peer)
+
+	currentTimestamp += 10
+	peer.Progress = 0.5
+	peerDownloaded, peerUploaded := Tr_EstimatePeer(torrentInfoHash, torrentTotalSize, peer)
+	if peerDownloaded != 0 {
+		t.Errorf("Downloaded = %d, want 0", peerDownloaded)
+	}
+	if peerUploaded != (1048576 * 10) {
+		t.Errorf("Uploaded = %d, want %d", peerUploaded, (1048576 * 10))
+	}
+
+	// 从 Peer 下载时按速率积分 (梯形法).
+	currentTimestamp += 10
+	peer.DlSpeed = 2097152
+	peerDownloaded, _ = Tr_EstimatePeer(torrentInfoHash, torrentTotalSize, peer)
+	if peerDownloaded != (1048576 * 10) {
+		t.Errorf("Downloaded = %d, want %d", peerDownloaded, (1048576 * 10))
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"bufio"
+	"errors"
+	"strings"
+	"strconv"
+	"compress/gzip"
+	"encoding/json"
+)
+
+type CaptureSnapshotStruct struct {
+	Timestamp  int64                      `json:"timestamp"`
+	ClientType string                     `json:"clientType"`
+	Torrents   json.RawMessage            `json:"torrents"`
+	Peers      map[string]json.RawMessage `json:"peers,omitempty"`
+	Trackers   map[string][]string        `json:"trackers,omitempty"`
+	Private    map[string]*bool           `json:"private,omitempty"`
+}
+
+var captureSnapshot *CaptureSnapshotStruct
+var replayMode = false
+var replaySnapshot *CaptureSnapshotStruct
+var replayBlockPeerMap = make(map[string]BlockPeerInfoStruct)
+
+func CaptureTorrents(torrents interface{}) {
+	if captureFilename == "" || replayMode {
+		return
+	}
+
+	torrentsJSON, err := json.Marshal(torrents)
+	if err != nil {
+		Log("CaptureTorrents", GetLangText("Error-GenJSON"), true, err.Error())
+		return
+	}
+
+	captureSnapshot = &CaptureSnapshotStruct { Timestamp: currentTimestamp, ClientType: currentClientType, Torrents: torrentsJSON, Peers: make(map[string]json.RawMessage), Trackers: make(map[string][]string), Private: make(map[string]*bool) }
+}
+func CaptureTorrentPeers(infoHash string, torrentPeers interface{}) {
+	if captureSnapshot == nil {
+		return
+	}
+
+	torrentPeersJSON, err := json.Marshal(torrentPeers)
+	if err != nil {
+		Log("CaptureTorrentPeers", GetLangText("Error-GenJSON"), true, err.Error())
+		return
+	}
+
+	captureSnapshot.Peers[infoHash] = torrentPeersJSON
+}
+func CaptureTorrentMeta(infoHash string, torrentTrackers []string, torrentPrivate *bool) {
+	// qBittorrent 的 Tracker 及私有标志需额外请求, 因此一并记录.
+	if captureSnapshot == nil {
+		return
+	}
+
+	captureSnapshot.Trackers[infoHash] = torrentTrackers
+	captureSnapshot.Private[infoHash] = torrentPrivate
+}
+func SaveCapture() {
+	if captureSnapshot == nil {
+		return
+	}
+
+	defer func() {
+		captureSnapshot = nil
+	}()
+
+	captureSnapshotJSON, err := json.Marshal(captureSnapshot)
+	if err != nil {
+		Log("SaveCapture", GetLangText("Error-GenJSON"), true, err.Error())
+		return
+	}
+
+	captureFile, err := os.OpenFile(captureFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		Log("SaveCapture", GetLangText("Error-SaveCapture"), true, err.Error())
+		return
+	}
+	defer captureFile.Close()
+
+	// 每个周期写入独立的 gzip 成员, 以免程序异常退出时损坏已写入的内容.
+	captureWriter := gzip.NewWriter(captureFile)
+	if _, err := captureWriter.Write(append(captureSnapshotJSON, '\n')); err != nil {
+		Log("SaveCapture", GetLangText("Error-SaveCapture"), true, err.Error())
+		return
+	}
+	if err := captureWriter.Close(); err != nil {
+		Log("SaveCapture", GetLangText("Error-SaveCapture"), true, err.Error())
+		return
+	}
+
+	Log("Debug-SaveCapture", "%d (Torrents: %d bytes, Peers: %d)", false, captureSnapshot.Timestamp, len(captureSnapshot.Torrents), len(captureSnapshot.Peers))
+}
+func ReplayFetchTorrents() interface{} {
+	if replaySnapshot == nil {
+		return nil
+	}
+
+	switch currentClientType {
+		case "qBittorrent":
+			var torrentsResult []qB_TorrentStruct
+			if err := json.Unmarshal(replaySnapshot.Torrents, &torrentsResult); err != nil {
+				Log("FetchTorrents", GetLangText("Error-Parse"), true, err.Error())
+				return nil
+			}
+			return &torrentsResult
+		case "Transmission":
+			var torrentsResult Tr_TorrentsStruct
+			if err := json.Unmarshal(replaySnapshot.Torrents, &torrentsResult); err != nil {
+				Log("FetchTorrents", GetLangText("Error-Parse"), true, err.Error())
+				return nil
+			}
+			return &torrentsResult
+	}
+
+	return nil
+}
+func ReplayFetchTorrentPeers(infoHash string) interface{} {
+	if replaySnapshot == nil {
+		return nil
+	}
+
+	torrentPeersJSON, exist := replaySnapshot.Peers[infoHash]
+	if !exist {
+		return nil
+	}
+
+	switch currentClientType {
+		case "qBittorrent":
+			var torrentPeersResult qB_TorrentPeersStruct
+			if err := json.Unmarshal(torrentPeersJSON, &torrentPeersResult); err != nil {
+				Log("FetchTorrentPeers", GetLangText("Error-Parse"), true, err.Error())
+				return nil
+			}
+			return &torrentPeersResult
+	}
+
+	return nil
+}
+func ReplayGetTorrentMeta(torrentInfo qB_TorrentStruct) ([]string, *bool) {
+	if torrentInfo.Private == nil && replaySnapshot != nil {
+		torrentInfo.Private = replaySnapshot.Private[torrentInfo.InfoHash]
+	}
+
+	if replaySnapshot != nil {
+		if torrentTrackers, exist := replaySnapshot.Trackers[torrentInfo.InfoHash]; exist {
+			return torrentTrackers, torrentInfo.Private
+		}
+	}
+
+	if torrentInfo.Tracker != "" {
+		return []string { torrentInfo.Tracker }, torrentInfo.Private
+	}
+
+	return []string {}, torrentInfo.Private
+}
+func WriteReplayDecision(action string, target string, detail string) {
+	os.Stdout.WriteString(strconv.FormatInt(currentTimestamp, 10) + "\t" + action + "\t" + target + "\t" + detail + "\n")
+}
+func ReplaySubmitBlockPeer(blockPeerMap map[string]BlockPeerInfoStruct) bool {
+	// 不提交至客户端, 而是输出与上次提交相比的变化. 按 IP 排序, 以便比较不同配置或版本的输出.
+	peerIPList := []string {}
+	for peerIP, _ := range blockPeerMap {
+		peerIPList = append(peerIPList, peerIP)
+	}
+	for peerIP, _ := range replayBlockPeerMap {
+		if _, exist := blockPeerMap[peerIP]; !exist {
+			peerIPList = append(peerIPList, peerIP)
+		}
+	}
+	sort.Strings(peerIPList)
+
+	for _, peerIP := range peerIPList {
+		peerInfo, exist := blockPeerMap[peerIP]
+		if !exist {
+			WriteReplayDecision("unban", peerIP, "")
+			continue
+		}
+
+		lastPeerInfo, lastExist := replayBlockPeerMap[peerIP]
+		newPortList := []int {}
+		for port, _ := range peerInfo.Port {
+			if !lastExist || !lastPeerInfo.Port[port] {
+				newPortList = append(newPortList, port)
+			}
+		}
+		if len(newPortList) <= 0 {
+			continue
+		}
+		sort.Ints(newPortList)
+
+		newPortStrList := []string {}
+		for _, port := range newPortList {
+			newPortStrList = append(newPortStrList, strconv.Itoa(port))
+		}
+		WriteReplayDecision("ban", peerIP, "Port: " + strings.Join(newPortStrList, ",") + ", TorrentInfoHash: " + peerInfo.InfoHash)
+	}
+
+	replayBlockPeerMap = make(map[string]BlockPeerInfoStruct)
+	for peerIP, peerInfo := range blockPeerMap {
+		peerPortMap := make(map[int]bool)
+		for port, _ := range peerInfo.Port {
+			peerPortMap[port] = true
+		}
+		peerInfo.Port = peerPortMap
+		replayBlockPeerMap[peerIP] = peerInfo
+	}
+
+	return true
+}
+func ReplaySetTorrentUploadLimit(infoHash string, uploadLimit int64) bool {
+	if uploadLimit < 0 {
+		WriteReplayDecision("unthrottle", infoHash, "")
+	} else {
+		WriteReplayDecision("throttle", infoHash, "UploadLimit: " + strconv.FormatInt(uploadLimit, 10))
+	}
+
+	return true
+}
+func ReadCaptureFile(filename string, processSnapshot func(CaptureSnapshotStruct)) error {
+	captureFile, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer captureFile.Close()
+
+	// gzip.Reader 默认支持多个成员, 即逐周期追加写入的文件.
+	captureReader, err := gzip.NewReader(captureFile)
+	if err != nil {
+		return err
+	}
+	defer captureReader.Close()
+
+	captureBufReader := bufio.NewReader(captureReader)
+	for {
+		captureSnapshotJSON, err := captureBufReader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(captureSnapshotJSON))) > 0 {
+			var snapshot CaptureSnapshotStruct
+			if err := json.Unmarshal(captureSnapshotJSON, &snapshot); err != nil {
+				return err
+			}
+			processSnapshot(snapshot)
+		}
+
+		if err != nil {
+			// 程序异常退出时, 最后一个 gzip 成员可能不完整.
+			if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			return nil
+		}
+	}
+}
+func RunReplay(filename string) int {
+	// 判定结果输出至标准输出, 日志输出至标准错误.
+	logOutput = os.Stderr
+
+	// 回放时不执行命令, 亦不等待.
+	if !LoadOfflineConfig(func(replayConfig *ConfigStruct) {
+		replayConfig.SleepTime = 0
+		replayConfig.ExecCommand_Ban = ""
+		replayConfig.ExecCommand_Unban = ""
+	}) {
+		return 1
+	}
+
+	replayMode = true
+	defer func() {
+		replayMode = false
+		replaySnapshot = nil
+	}()
+
+	replayCount := 0
+	err := ReadCaptureFile(filename, func(snapshot CaptureSnapshotStruct) {
+		replaySnapshot = &snapshot
+		currentClientType = snapshot.ClientType
+		currentTimestamp = snapshot.Timestamp
+		Task()
+		GC()
+		replayCount++
+	})
+	if err != nil {
+		Log("RunReplay", GetLangText("Error-LoadCapture"), false, filename, err.Error())
+		return 1
+	}
+
+	Log("RunReplay", GetLangText("Success-RunReplay"), false, replayCount, len(blockPeerMap))
+
+	return 0
+}
@@ -17,12 +17,10 @@ func IsSupportClient() bool {
 		default:
 			return false
 	}
-
-	return false
 }
 func InitClient() {
-	if currentClientType == "Transmission" {
-		Tr_InitClient()
+	if IsServerRequired() {
+		StartServer()
 	}
 }
 func SetURLFromClient() {
@@ -63,36 +61,81 @@ func Login() bool {
 	return false
 }
 func FetchTorrents() interface{} {
+	if replayMode {
+		return ReplayFetchTorrents()
+	}
+
 	switch currentClientType {
 		case "qBittorrent":
 			maindata := qB_FetchTorrents()
 			if maindata == nil {
 				return nil
 			}
+			CaptureTorrents(maindata)
 			return maindata
 		case "Transmission":
 			maindata := Tr_FetchTorrents()
 			if maindata == nil {
 				return nil
 			}
+			CaptureTorrents(maindata)
 			return maindata
 	}
 
 	return nil
 }
 func FetchTorrentPeers(infoHash string) interface{} {
+	if replayMode {
+		return ReplayFetchTorrentPeers(infoHash)
+	}
+
 	switch currentClientType {
 		case "qBittorrent":
 			torrentPeers := qB_FetchTorrentPeers(infoHash)
 			if torrentPeers == nil {
 				return nil
 			}
+			CaptureTorrentPeers(infoHash, torrentPeers)
 			return torrentPeers
 	}
 
 	return nil
 }
+func GetTorrentUploadLimit(infoHash string) (int64, bool) {
+	// 回放时视为原本不限速.
+	if replayMode {
+		return -1, true
+	}
+
+	switch currentClientType {
+		case "qBittorrent":
+			return qB_GetTorrentUploadLimit(infoHash)
+		case "Transmission":
+			return Tr_GetTorrentUploadLimit(infoHash)
+	}
+
+	return 0, false
+}
+func SetTorrentUploadLimit(infoHash string, uploadLimit int64) bool {
+	// uploadLimit 单位为 B/s, -1 表示不限速.
+	if replayMode {
+		return ReplaySetTorrentUploadLimit(infoHash, uploadLimit)
+	}
+
+	switch currentClientType {
+		case "qBittorrent":
+			return qB_SetTorrentUploadLimit(infoHash, uploadLimit)
+		case "Transmission":
+			return Tr_SetTorrentUploadLimit(infoHash, uploadLimit)
+	}
+
+	return false
+}
 func SubmitBlockPeer(blockPeerMap map[string]BlockPeerInfoStruct) bool {
+	if replayMode {
+		return ReplaySubmitBlockPeer(blockPeerMap)
+	}
+
 	switch currentClientType {
 		case "qBittorrent":
 			return qB_SubmitBlockPeer(blockPeerMap)
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net"
+	"time"
+	"strings"
+	"strconv"
+	"testing"
+	"net/url"
+)
+
+func SetupFakeClientConfig(t *testing.T, f *FakeClientStruct) {
+	lastConfig := config
+	lastClientType := currentClientType
+	lastTimestamp := currentTimestamp
+	lastBlockPeerMap := blockPeerMap
+	lastBlockCIDRMap := blockCIDRMap
+	lastIPMap := ipMap
+	lastTorrentMap := torrentMap
+	lastCSRFToken := Tr_csrfToken
+	lastUseNewBanPeersMethod := qB_useNewBanPeersMethod
+	t.Cleanup(func() {
+		StopServer()
+		// 恢复的默认配置会将日志写入 logs 目录, 测试中不应创建.
+		config = lastConfig
+		config.LogToFile = false
+		InitConfig()
+		currentClientType = lastClientType
+		currentTimestamp = lastTimestamp
+		blockPeerMap = lastBlockPeerMap
+		blockCIDRMap = lastBlockCIDRMap
+		ipMap = lastIPMap
+		torrentMap = lastTorrentMap
+		Tr_csrfToken = lastCSRFToken
+		qB_useNewBanPeersMethod = lastUseNewBanPeersMethod
+		requestFailedCount = 0
+		requestCircuitOpenUntil = 0
+	})
+
+	// 每个客户端均从空白的状态开始.
+	currentClientType = ""
+	currentTimestamp = time.Now().Unix()
+	blockPeerMap = make(map[string]BlockPeerInfoStruct)
+	blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
+	ipMap = make(map[string]IPInfoStruct)
+	torrentMap = make(map[string]TorrentInfoStruct)
+	Tr_csrfToken = ""
+	requestFailedCount = 0
+	requestCircuitOpenUntil = 0
+
+	fakeConfig := DeepCopyConfig(defaultConfig)
+	fakeConfig.ClientURL = f.URL()
+	fakeConfig.ClientUsername = f.Username
+	fakeConfig.ClientPassword = f.Password
+	fakeConfig.BlockList = []string { "Xunlei" }
+	fakeConfig.BanAllPort = false
+	fakeConfig.Interval = 1
+	fakeConfig.SleepTime = 0
+	fakeConfig.LogToFile = false
+	fakeConfig.Listen = ""
+	if f.ClientType == "Transmission" {
+		// Transmission 启用认证时, 未认证的请求返回 401 而非 409, 因此无法自动检测, 须指定 clientType.
+		fakeConfig.ClientType = f.ClientType
+		fakeConfig.UseBasicAuth = true
+		fakeConfig.Listen = GetFreeListen(t)
+	}
+	config = fakeConfig
+	InitConfig()
+}
+func GetFreeListen(t *testing.T) string {
+	// 获取本机的空闲端口, 供 Transmission 下载 ipfilter.dat.
+	testListen, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer testListen.Close()
+
+	return testListen.Addr().String()
+}
+func TestQBittorrentEndToEnd(t *testing.T) {
+	f := StartFakeClient(t, "qBittorrent")
+	SetupFakeClientConfig(t, f)
+	qB_useNewBanPeersMethod = true
+
+	if !DetectClient() || currentClientType != "qBittorrent" {
+		t.Fatalf("DetectClient: currentClientType = %q", currentClientType)
+	}
+	if !Login() || f.LoginCount != 1 {
+		t.Fatalf("Login: LoginCount = %d", f.LoginCount)
+	}
+
+	Task()
+
+	if _, exist := blockPeerMap["203.0.113.7"]; !exist {
+		t.Error("bad peer 203.0.113.7 was not detected")
+	}
+	if _, exist := blockPeerMap["198.51.100.8"]; exist {
+		t.Error("good peer 198.51.100.8 was banned")
+	}
+	if len(f.BanPeersBody) != 1 || f.BanPeersBody[0] != url.QueryEscape("203.0.113.7:6881") {
+		t.Errorf("banPeers body = %q, want %q", f.BanPeersBody, url.QueryEscape("203.0.113.7:6881"))
+	}
+
+	// 会话过期后, 应重新登录并重试原请求.
+	f.ExpireSession()
+	if FetchTorrents() == nil || f.LoginCount != 2 {
+		t.Errorf("FetchTorrents after session expired: LoginCount = %d, want 2", f.LoginCount)
+	}
+}
+func TestTransmissionEndToEnd(t *testing.T) {
+	f := StartFakeClient(t, "Transmission")
+	SetupFakeClientConfig(t, f)
+
+	if !DetectClient() || currentClientType != "Transmission" {
+		t.Fatalf("DetectClient: currentClientType = %q", currentClientType)
+	}
+
+	// 首个请求未携带 CSRF Token, 应在收到 409 后设置 Token 并重试.
+	if !Login() || f.CSRFCount != 1 || Tr_csrfToken != "fake-session-1" {
+		t.Fatalf("Login: CSRFCount = %d, Tr_csrfToken = %q", f.CSRFCount, Tr_csrfToken)
+	}
+	if len(f.RPCBody) != 1 || !strings.Contains(f.RPCBody[0], "\"session-get\"") {
+		t.Fatalf("Login: RPC body = %q", f.RPCBody)
+	}
+
+	// RestartServer 返回时已同步开始监听.
+	RestartServer()
+	if !Server_Status {
+		t.Fatal("StartServer failed")
+	}
+
+	Task()
+
+	if _, exist := blockPeerMap["203.0.113.7"]; !exist {
+		t.Error("bad peer 203.0.113.7 was not detected")
+	}
+	if _, exist := blockPeerMap["198.51.100.8"]; exist {
+		t.Error("good peer 198.51.100.8 was banned")
+	}
+	// 设置 blocklist-url 后, 应请求 Transmission 更新列表.
+	blocklistUpdateIndex := -1
+	for k, rpcBody := range f.RPCBody {
+		if strings.Contains(rpcBody, "\"session-set\"") && (k + 1) < len(f.RPCBody) {
+			blocklistUpdateIndex = (k + 1)
+			break
+		}
+	}
+	if blocklistUpdateIndex < 0 || f.RPCBody[blocklistUpdateIndex] != `{"method":"blocklist-update"}` {
+		t.Errorf("RPC body = %q, want %q after session-set", f.RPCBody, `{"method":"blocklist-update"}`)
+	}
+	if blocklistURL := "http://" + config.Listen + "/ipfilter.dat?t=" + strconv.FormatInt(currentTimestamp, 10); f.BlocklistURL != blocklistURL {
+		t.Errorf("blocklist-url = %q, want %q", f.BlocklistURL, blocklistURL)
+	}
+	if blocklist := "203.0.113.7/32\n::ffff:203.0.113.7 - ::ffff:203.0.113.7 , 000\n"; f.Blocklist != blocklist {
+		t.Errorf("blocklist = %q, want %q", f.Blocklist, blocklist)
+	}
+
+	// Token 失效后, 应获取新的 Token 并重试原请求.
+	f.ExpireSession()
+	if FetchTorrents() == nil || f.CSRFCount != 2 || Tr_csrfToken != "fake-session-2" {
+		t.Errorf("FetchTorrents after session expired: CSRFCount = %d, Tr_csrfToken = %q", f.CSRFCount, Tr_csrfToken)
+	}
+}
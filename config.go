@@ -5,15 +5,19 @@ import (
 	"net"
 	"time"
 	"flag"
+	"errors"
 	"regexp"
 	"reflect"
+	"runtime"
 	"strings"
 	"crypto/tls"
 	"encoding/json"
 	"path/filepath"
 	"net/http"
 	"net/http/cookiejar"
+	"gopkg.in/yaml.v3"
 	"github.com/tidwall/jsonc"
+	"github.com/BurntSushi/toml"
 )
 
 type ConfigStruct struct {
@@ -28,8 +32,14 @@ type ConfigStruct struct {
 	BanAllPort                    bool
 	BanIPCIDR                     string
 	BanIP6CIDR                    string
+	CIDRPromotionCount            uint32
+	CIDRPromotionWindow           uint32
+	CIDRPromotionLadder           []string
+	CIDRPromotion6Ladder          []string
 	IgnoreEmptyPeer               bool
 	IgnorePTTorrent               bool
+	PTTrackerList                 []string
+	PublicTrackerList             []string
 	StartDelay                    uint32
 	SleepTime                     uint32
 	Timeout                       uint32
@@ -38,25 +48,38 @@ type ConfigStruct struct {
 	LogToFile                     bool
 	LogDebug                      bool
 	Listen                        string
+	ControlSocket                 string
+	ControlListen                 bool
 	ClientType                    string 
 	ClientURL                     string
+	ClientConfigPaths             []string
 	ClientUsername                string
 	ClientPassword                string
+	ClientPasswordFile            string
 	UseBasicAuth                  bool
 	SkipCertVerification          bool
 	ExecCommand_Ban               string
 	ExecCommand_Unban             string
 	BlockList                     []string
 	BlockListURL                  string
-	PortBlockList                 []uint32
+	PortBlockList                 []PortBlockConfigStruct
+	BanByPeerIDMismatch           bool
 	IPBlockList                   []string
 	IPBlockListURL                string
+	GeoIPASNDatabase              string
+	GeoIPCountryDatabase          string
 	IgnoreByDownloaded            uint32
 	IPUploadedCheck               bool
 	IPUpCheckInterval             uint32
 	IPUpCheckIncrementMB          uint32
 	IPUpCheckPerTorrentRatio      float64
 	MaxIPPortCount                uint32
+	BanByCorrelation              bool
+	CorrelationIPCIDR             string
+	CorrelationIP6CIDR            string
+	CorrelationMinTorrents        uint32
+	CorrelationMaxProgress        float64
+	CorrelationMinIPPerTorrent    uint32
 	BanByProgressUploaded         bool
 	BanByPUStartMB                uint32
 	BanByPUStartPrecent           float64
@@ -65,6 +88,15 @@ type ConfigStruct struct {
 	BanByRelativePUStartMB        uint32
 	BanByRelativePUStartPrecent   float64
 	BanByRelativePUAntiErrorRatio float64
+	BanByStagnantProgress         bool
+	StagnantProgressCycles        uint32
+	StagnantProgressUploadedMB    uint32
+	StagnantProgressGracePeriod   uint32
+	ThrottleUploadLimit           uint32
+	ThrottleEscalateCount         uint32
+	ThrottleStateFile             string
+	Rules                         []RuleConfigStruct
+	TorrentOverrides              []TorrentOverrideConfigStruct
 }
 
 var programName = "qBittorrent-ClientBlocker"
@@ -72,8 +104,15 @@ var programVersion = "Unknown"
 var shortFlag_ShowVersion bool
 var longFlag_ShowVersion bool
 var noChdir bool
+var checkConfigOnly bool
+var testPeerQuery string
+var testPeerFilename string
+var captureFilename string
+var replayFilename string
 
 var randomStrRegexp = regexp.MustCompile("[a-zA-Z0-9]{32}")
+var configEnvReferenceRegexp = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+var secretConfigFieldMap = map[string]bool { "ClientPassword": true }
 var blockListCompiled []*regexp.Regexp
 var blockListFromURLCompiled []*regexp.Regexp
 var ipBlockListCompiled []*net.IPNet
@@ -83,8 +122,11 @@ var cookieJar, _ = cookiejar.New(nil)
 var lastURL = ""
 var configFilename string
 var configLastMod int64 = 0
+var configFileContent []byte
 var additionConfigFilename string
 var additionConfigLastMod int64 = 0
+var additionalConfigFileContent []byte
+var clientRelogin = false
 var ipBlockListLastFetch int64 = 0
 var blockListLastFetch int64 = 0
 
@@ -98,11 +140,8 @@ var httpTransport = &http.Transport {
 }
 var httpClient http.Client
 var httpClientWithoutCookie http.Client
-var httpServer = http.Server {
-	ReadTimeout:  30,
-	WriteTimeout: 30,
-	Handler:      &httpServerHandler {},
-}
+var httpServer *http.Server
+var httpServerTimeout time.Duration = 30
 
 var config = ConfigStruct {
 	Debug:                         false,
@@ -116,8 +155,14 @@ var config = ConfigStruct {
 	BanAllPort:                    false,
 	BanIPCIDR:                     "/32",
 	BanIP6CIDR:                    "/128",
+	CIDRPromotionCount:            0,
+	CIDRPromotionWindow:           3600,
+	CIDRPromotionLadder:           []string { "/24" },
+	CIDRPromotion6Ladder:          []string { "/64", "/56", "/48" },
 	IgnoreEmptyPeer:               true,
 	IgnorePTTorrent:               true,
+	PTTrackerList:                 []string {},
+	PublicTrackerList:             []string {},
 	StartDelay:                    0,
 	SleepTime:                     20,
 	Timeout:                       6,
@@ -126,25 +171,38 @@ var config = ConfigStruct {
 	LogToFile:                     true,
 	LogDebug:                      false,
 	Listen:                        ":26262",
+	ControlSocket:                 "",
+	ControlListen:                 false,
 	ClientType:                    "",
 	ClientURL:                     "",
+	ClientConfigPaths:             []string {},
 	ClientUsername:                "",
 	ClientPassword:                "",
+	ClientPasswordFile:            "",
 	UseBasicAuth:                  false,
 	SkipCertVerification:          false,
 	ExecCommand_Ban:               "",
 	ExecCommand_Unban:             "",
 	BlockList:                     []string {},
 	BlockListURL:                  "",
-	PortBlockList:                 []uint32 {},
+	PortBlockList:                 []PortBlockConfigStruct {},
+	BanByPeerIDMismatch:           false,
 	IPBlockList:                   []string {},
 	IPBlockListURL:                "",
+	GeoIPASNDatabase:              "",
+	GeoIPCountryDatabase:          "",
 	IgnoreByDownloaded:            100,
 	IPUploadedCheck:               false,
 	IPUpCheckInterval:             300,
 	IPUpCheckIncrementMB:          38000,
 	IPUpCheckPerTorrentRatio:      3,
 	MaxIPPortCount:                0,
+	BanByCorrelation:              false,
+	CorrelationIPCIDR:             "/24",
+	CorrelationIP6CIDR:            "/64",
+	CorrelationMinTorrents:        5,
+	CorrelationMaxProgress:        1,
+	CorrelationMinIPPerTorrent:    4,
 	BanByProgressUploaded:         false,
 	BanByPUStartMB:                20,
 	BanByPUStartPrecent:           2,
@@ -153,25 +211,29 @@ var config = ConfigStruct {
 	BanByRelativePUStartMB:        20,
 	BanByRelativePUStartPrecent:   2,
 	BanByRelativePUAntiErrorRatio: 3,
+	BanByStagnantProgress:         false,
+	StagnantProgressCycles:        3,
+	StagnantProgressUploadedMB:    50,
+	StagnantProgressGracePeriod:   300,
+	ThrottleUploadLimit:           512,
+	ThrottleEscalateCount:         3,
+	ThrottleStateFile:             "throttle.json",
+	Rules:                         []RuleConfigStruct {},
+	TorrentOverrides:              []TorrentOverrideConfigStruct {},
 }
 func SetIPBlockListFromURL() bool {
 	if config.IPBlockListURL == "" || (ipBlockListLastFetch + int64(config.UpdateInterval)) > currentTimestamp {
 		return true
 	}
 
-	_, ipBlockListContent := Fetch(config.IPBlockListURL, false, false, nil)
-	if ipBlockListContent == nil {
-		Log("SetIPBlockListFromURL", GetLangText("Error-FetchResponse"), true)
-		return false
-	}
-
 	// Max 8MB.
-	if len(ipBlockListContent) > 8388608 {
-		Log("SetIPBlockListFromURL", GetLangText("Error-LargeFile"), true)
+	ipBlockListResponse, err := DoRequest(RequestStruct { URL: config.IPBlockListURL, MaxSize: 8388608 })
+	if err != nil {
+		Log("SetIPBlockListFromURL", "%s", true, err.Error())
 		return false
 	}
 
-	ipBlockListArr := strings.Split(string(ipBlockListContent), "\n")
+	ipBlockListArr := strings.Split(string(ipBlockListResponse.Body), "\n")
 	ipBlockListFromURLCompiled = make([]*net.IPNet, len(ipBlockListArr))
 	k := 0
 	for ipBlockListLineNum, ipBlockListLine := range ipBlockListArr {
@@ -209,19 +271,14 @@ func SetBlockListFromURL() bool {
 		return true
 	}
 
-	_, blockListContent := Fetch(config.BlockListURL, false, false, nil)
-	if blockListContent == nil {
-		Log("SetBlockListFromURL", GetLangText("Error-FetchResponse"), true)
-		return false
-	}
-
 	// Max 8MB.
-	if len(blockListContent) > 8388608 {
-		Log("SetBlockListFromURL", GetLangText("Error-LargeFile"), true)
+	blockListResponse, err := DoRequest(RequestStruct { URL: config.BlockListURL, MaxSize: 8388608 })
+	if err != nil {
+		Log("SetBlockListFromURL", "%s", true, err.Error())
 		return false
 	}
 
-	blockListArr := strings.Split(string(blockListContent), "\n")
+	blockListArr := strings.Split(string(blockListResponse.Body), "\n")
 	blockListFromURLCompiled = make([]*regexp.Regexp, len(blockListArr))
 	k := 0
 	for blockListLineNum, blockListLine := range blockListArr {
@@ -255,6 +312,39 @@ func SetBlockListFromURL() bool {
 
 	return false
 }
+func GetConfigFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+		case ".yaml", ".yml":
+			return "yaml"
+		case ".toml":
+			return "toml"
+	}
+
+	return "json"
+}
+func ConvertConfigToJSON(filename string, configFile []byte) ([]byte, error) {
+	// 各格式均转换为 JSON 后再解析, 使配置项名称, 默认值, 合并及热重载的行为保持一致.
+	var configMap map[string]interface{}
+
+	switch GetConfigFormat(filename) {
+		case "yaml":
+			if err := yaml.Unmarshal(configFile, &configMap); err != nil {
+				return nil, err
+			}
+		case "toml":
+			if err := toml.Unmarshal(configFile, &configMap); err != nil {
+				return nil, err
+			}
+		default:
+			return jsonc.ToJSON(configFile), nil
+	}
+
+	if configMap == nil {
+		configMap = make(map[string]interface{})
+	}
+
+	return json.Marshal(configMap)
+}
 func LoadConfig() int {
 	configFileStat, err := os.Stat(configFilename)
 	if err != nil {
@@ -279,11 +369,25 @@ func LoadConfig() int {
 
 	configLastMod = tmpConfigLastMod
 
-	if err := json.Unmarshal(jsonc.ToJSON(configFile), &config); err != nil {
+	LogConfigIssues("LoadConfig_Validate", ValidateConfigKeys(configFilename, configFile), true)
+
+	// 仅检查能否解析, 解析失败时保留上次成功读取的内容, 由 ApplyConfig 统一合并.
+	configJSON, err := ConvertConfigToJSON(configFilename, configFile)
+	if err != nil {
+		Log("LoadConfig", GetLangText("Error-ParseConfig"), true, err.Error())
+		return -4
+	}
+
+	parsedConfig := ConfigStruct {}
+	if err := json.Unmarshal(configJSON, &parsedConfig); err != nil {
 		Log("LoadConfig", GetLangText("Error-ParseConfig"), true, err.Error())
 		return -4
 	}
 
+	CheckConfigPermission(configFilename, configFileStat, parsedConfig)
+
+	configFileContent = configJSON
+
 	Log("LoadConfig", GetLangText("Success-LoadConfig"), true)
 
 	return 0
@@ -293,6 +397,11 @@ func LoadAdditionalConfig() int {
 	if err != nil {
 		if !os.IsNotExist(err) {
 			Log("Debug-LoadAdditionalConfig", GetLangText("Error-LoadConfigMeta"), false, err.Error())
+		} else if additionalConfigFileContent != nil {
+			// 附加配置文件已被删除, 其配置项不再生效.
+			additionConfigLastMod = 0
+			additionalConfigFileContent = nil
+			return 0
 		}
 		return -2
 	}
@@ -314,15 +423,177 @@ func LoadAdditionalConfig() int {
 
 	additionConfigLastMod = tmpAdditionalConfigLastMod
 
-	if err := json.Unmarshal(jsonc.ToJSON(additionalConfigFile), &config); err != nil {
+	LogConfigIssues("LoadAdditionalConfig_Validate", ValidateConfigKeys(additionConfigFilename, additionalConfigFile), true)
+
+	additionalConfigJSON, err := ConvertConfigToJSON(additionConfigFilename, additionalConfigFile)
+	if err != nil {
+		Log("LoadAdditionalConfig", GetLangText("Error-ParseConfig"), true, err.Error())
+		return -4
+	}
+
+	parsedConfig := ConfigStruct {}
+	if err := json.Unmarshal(additionalConfigJSON, &parsedConfig); err != nil {
 		Log("LoadAdditionalConfig", GetLangText("Error-ParseConfig"), true, err.Error())
 		return -4
 	}
 
+	CheckConfigPermission(additionConfigFilename, additionalConfigFileStat, parsedConfig)
+
+	additionalConfigFileContent = additionalConfigJSON
+
 	Log("LoadAdditionalConfig", GetLangText("Success-LoadConfig"), true)
 
 	return 0
 }
+func BuildConfig() (ConfigStruct, error) {
+	// 以默认配置为基础依次合并配置文件, 附加配置文件及环境变量, 已删除的配置项将恢复默认值.
+	newConfig := DeepCopyConfig(defaultConfig)
+
+	for _, configContent := range [][]byte { configFileContent, additionalConfigFileContent } {
+		if configContent == nil {
+			continue
+		}
+		if err := json.Unmarshal(configContent, &newConfig); err != nil {
+			return newConfig, err
+		}
+	}
+
+	// 环境变量优先于配置文件.
+	if err := ApplyEnvConfig(&newConfig); err != nil {
+		return newConfig, err
+	}
+
+	// 客户端地址及账号可能在启动后从客户端配置文件读取, 未配置时保留当前值.
+	if newConfig.ClientURL == "" {
+		newConfig.ClientURL = config.ClientURL
+		if newConfig.ClientUsername == "" {
+			newConfig.ClientUsername = config.ClientUsername
+		}
+	}
+
+	if err := ResolveConfigSecrets(&newConfig); err != nil {
+		return newConfig, err
+	}
+
+	return newConfig, nil
+}
+func ResolveConfigEnvReference(value string) (string, error) {
+	// 仅当整个值为 ${NAME} 时才视为环境变量引用, 防止误处理含有 $ 的密码.
+	matches := configEnvReferenceRegexp.FindStringSubmatch(value)
+	if matches == nil {
+		return value, nil
+	}
+
+	envValue, exist := os.LookupEnv(matches[1])
+	if !exist {
+		return "", errors.New("environment variable " + matches[1] + " is not set")
+	}
+
+	return envValue, nil
+}
+func ResolveConfigSecrets(cfg *ConfigStruct) error {
+	var err error
+
+	if cfg.ClientUsername, err = ResolveConfigEnvReference(cfg.ClientUsername); err != nil {
+		return err
+	}
+
+	// 密码文件 (如 Docker secrets) 优先于 clientPassword.
+	if cfg.ClientPasswordFile != "" {
+		passwordFile, err := os.ReadFile(cfg.ClientPasswordFile)
+		if err != nil {
+			return err
+		}
+		cfg.ClientPassword = strings.TrimRight(string(passwordFile), "\r\n")
+		return nil
+	}
+
+	cfg.ClientPassword, err = ResolveConfigEnvReference(cfg.ClientPassword)
+	return err
+}
+func CheckConfigPermission(filename string, fileStat os.FileInfo, parsedConfig ConfigStruct) {
+	// Windows 下的权限位没有意义. 仅当配置文件中直接包含密码时才提示.
+	if runtime.GOOS == "windows" || parsedConfig.ClientPassword == "" || configEnvReferenceRegexp.MatchString(parsedConfig.ClientPassword) {
+		return
+	}
+
+	if fileStat.Mode().Perm() & 0004 != 0 {
+		Log("CheckConfigPermission", GetLangText("Warning-ConfigPermission"), true, filename, fileStat.Mode().Perm().String())
+	}
+}
+func GetConfigChangedKeys(oldConfig ConfigStruct, newConfig ConfigStruct) []string {
+	changedKeys := []string {}
+
+	t := reflect.TypeOf(oldConfig)
+	oldValue := reflect.ValueOf(oldConfig)
+	newValue := reflect.ValueOf(newConfig)
+	for k := 0; k < t.NumField(); k++ {
+		if !reflect.DeepEqual(oldValue.Field(k).Interface(), newValue.Field(k).Interface()) {
+			fieldName := t.Field(k).Name
+			changedKeys = append(changedKeys, strings.ToLower(fieldName[:1]) + fieldName[1:])
+		}
+	}
+
+	return changedKeys
+}
+func ApplyConfig(firstLoad bool) bool {
+	newConfig, err := BuildConfig()
+	if err != nil {
+		Log("ApplyConfig", GetLangText("Error-ParseConfig"), true, err.Error())
+		return false
+	}
+
+	// 热重载时, 新配置存在任一错误均保留当前配置; 首次加载时则没有可保留的配置.
+	errorCount := LogConfigIssues("LoadConfig_Validate", ValidateConfigValues(newConfig, true), true)
+	if errorCount > 0 && !firstLoad {
+		Log("ApplyConfig", GetLangText("Failed-ApplyConfig"), true, errorCount)
+		return false
+	}
+
+	changedKeys := GetConfigChangedKeys(config, newConfig)
+	if !firstLoad {
+		for _, changedKey := range changedKeys {
+			Log("ApplyConfig", GetLangText("ApplyConfig_Changed"), true, changedKey)
+		}
+	}
+
+	oldConfig := config
+	config = newConfig
+	InitConfig()
+
+	if !firstLoad {
+		ReconfigureRuntime(oldConfig)
+	}
+
+	Log("ApplyConfig", GetLangText("Success-ApplyConfig"), true, len(changedKeys))
+
+	return true
+}
+func ReconfigureRuntime(oldConfig ConfigStruct) {
+	// 热重载时, 使启动时才读取的配置项同样生效.
+	if loopTicker != nil && oldConfig.Interval != config.Interval {
+		loopTicker.Reset(time.Duration(config.Interval) * time.Second)
+		Log("ReconfigureRuntime", GetLangText("ReconfigureRuntime_Interval"), true, config.Interval)
+	}
+
+	// 内置服务器的超时于创建时设置, 因此 timeout 变更时同样需要重启.
+	if (oldConfig.Listen != config.Listen || oldConfig.ControlListen != config.ControlListen || oldConfig.Timeout != config.Timeout) && (Server_Status || IsServerRequired()) {
+		if oldConfig.Listen != config.Listen {
+			Log("ReconfigureRuntime", GetLangText("ReconfigureRuntime_Listen"), true, config.Listen)
+		}
+		RestartServer()
+	}
+
+	if oldConfig.ControlSocket != config.ControlSocket {
+		StopControlServer()
+		StartControlServer()
+	}
+
+	// 客户端地址变更时将重新检测客户端, 因此仅需处理认证信息的变更.
+	if oldConfig.ClientURL == config.ClientURL && (oldConfig.ClientUsername != config.ClientUsername || oldConfig.ClientPassword != config.ClientPassword || oldConfig.UseBasicAuth != config.UseBasicAuth) {
+		clientRelogin = true
+	}
+}
 func InitConfig() {
 	if !LoadLog() && logFile != nil {
 		logFile.Close()
@@ -337,10 +608,22 @@ func InitConfig() {
 		config.Timeout = 1
 	}
 
+	if config.StagnantProgressCycles < 1 {
+		config.StagnantProgressCycles = 1
+	}
+
+	if config.ThrottleUploadLimit < 1 {
+		config.ThrottleUploadLimit = 1
+	}
+
 	if config.ClientURL != "" {
 		config.ClientURL = strings.TrimRight(config.ClientURL, "/")
 	}
 
+	// 重建 Transport 而非修改正在使用中的 Transport, 并关闭旧的空闲连接, 使设置变更立即生效.
+	httpTransport.CloseIdleConnections()
+	httpTransport = httpTransport.Clone()
+
 	if config.SkipCertVerification {
 		httpTransport.TLSClientConfig = &tls.Config { InsecureSkipVerify: true }
 	} else {
@@ -348,10 +631,9 @@ func InitConfig() {
 	}
 
 	httpTransportWithoutCookie := httpTransport.Clone()
+	httpTransportWithoutCookie.DisableKeepAlives = true
 
-	if config.LongConnection {
-		httpTransport.DisableKeepAlives = false
-	}
+	httpTransport.DisableKeepAlives = !config.LongConnection
 
 	currentTimeout := time.Duration(config.Timeout) * time.Second
 
@@ -372,13 +654,16 @@ func InitConfig() {
 	    },
 	}
 
-	httpServer.ReadTimeout = currentTimeout
-	httpServer.WriteTimeout = currentTimeout
+	httpServerTimeout = currentTimeout
 
 	t := reflect.TypeOf(config)
 	v := reflect.ValueOf(config)
 	for k := 0; k < t.NumField(); k++ {
-		Log("LoadConfig_Current", "%v: %v", true, t.Field(k).Name, v.Field(k).Interface())
+		fieldValue := v.Field(k).Interface()
+		if secretConfigFieldMap[t.Field(k).Name] && fieldValue != "" {
+			fieldValue = "******"
+		}
+		Log("LoadConfig_Current", "%v: %v", true, t.Field(k).Name, fieldValue)
 	}
 
 	blockListCompiled = make([]*regexp.Regexp, len(config.BlockList))
@@ -394,6 +679,17 @@ func InitConfig() {
 		blockListCompiled[k] = reg
 	}
 
+	for _, cidr := range config.CIDRPromotionLadder {
+		if ParseIPCIDR("0.0.0.0" + cidr) == nil {
+			Log("LoadConfig_CheckCIDRPromotionLadder", GetLangText("Error-CIDRPromotionLadder"), true, cidr)
+		}
+	}
+	for _, cidr := range config.CIDRPromotion6Ladder {
+		if ParseIPCIDR("::" + cidr) == nil {
+			Log("LoadConfig_CheckCIDRPromotionLadder", GetLangText("Error-CIDRPromotionLadder"), true, cidr)
+		}
+	}
+
 	ipBlockListCompiled = make([]*net.IPNet, len(config.IPBlockList))
 	for k, v := range config.IPBlockList {
 		Log("Debug-LoadConfig_CompileIPBlockList", "%s", false, v)
@@ -406,6 +702,10 @@ func InitConfig() {
 
 		ipBlockListCompiled[k] = cidr
 	}
+
+	CompilePortBlockList()
+	CompileRules()
+	CompileTorrentOverrides()
 }
 func LoadInitConfig(firstLoad bool) bool {
 	lastURL = config.ClientURL
@@ -414,7 +714,7 @@ func LoadInitConfig(firstLoad bool) bool {
 	if loadConfigStatus >= -1 {
 		loadAdditionalConfigStatus := LoadAdditionalConfig()
 		if loadConfigStatus == 0 || loadAdditionalConfigStatus == 0 {
-			InitConfig()
+			ApplyConfig(firstLoad)
 		}
 	} else {
 		Log("LoadInitConfig", GetLangText("Failed-LoadInitConfig"), true)
@@ -433,17 +733,24 @@ func LoadInitConfig(firstLoad bool) bool {
 			}
 			SubmitBlockPeer(nil)
 			lastURL = config.ClientURL
+		} else if clientRelogin {
+			Log("LoadInitConfig", GetLangText("LoadInitConfig_Relogin"), true)
+			Login()
 		}
 	} else {
 		// 重置为上次使用的 URL, 主要目的是防止热重载配置文件可能破坏首次启动后从 qBittorrent 配置文件读取的 URL.
 		config.ClientURL = lastURL
 	}
 
+	clientRelogin = false
+
 	if !firstLoad {
 		SetIPBlockListFromURL()
 		SetBlockListFromURL()
 	}
 
+	LoadAllGeoIPDatabase()
+
 	return true
 }
 func RegFlag() {
@@ -455,7 +762,15 @@ func RegFlag() {
 	flag.StringVar(&additionConfigFilename, "config_additional", "config_additional.json", GetLangText("AdditionalConfigPath"))
 	flag.BoolVar(&config.Debug, "debug", false, GetLangText("DebugMode"))
 	flag.BoolVar(&noChdir, "nochdir", false, GetLangText("NoChdir"))
+	flag.BoolVar(&checkConfigOnly, "check-config", false, GetLangText("CheckConfig"))
+	flag.StringVar(&testPeerQuery, "test-peer", "", GetLangText("TestPeer"))
+	flag.StringVar(&testPeerFilename, "test-file", "", GetLangText("TestFile"))
+	flag.StringVar(&captureFilename, "capture", "", GetLangText("Capture"))
+	flag.StringVar(&replayFilename, "replay", "", GetLangText("Replay"))
 	flag.Parse()
+
+	// 命令行参数作为默认配置, 使热重载时未配置的配置项仍保留命令行参数的值.
+	defaultConfig.Debug = config.Debug
 }
 func ShowVersion() {
 	Log("ShowVersion", "%s %s", false, programName, programVersion)
@@ -463,6 +778,12 @@ func ShowVersion() {
 func PrepareEnv() bool {
 	LoadLang(GetLangCode())
 	RegFlag()
+
+	// 子命令及离线测试模式下, 日志输出至标准错误.
+	if flag.NArg() > 0 || testPeerQuery != "" || testPeerFilename != "" || replayFilename != "" {
+		logOutput = os.Stderr
+	}
+
 	ShowVersion()
 
 	if shortFlag_ShowVersion || longFlag_ShowVersion {
@@ -486,5 +807,24 @@ func PrepareEnv() bool {
 		}
 	}
 
+	if checkConfigOnly {
+		if !CheckConfig() {
+			os.Exit(1)
+		}
+		return false
+	}
+
+	if testPeerQuery != "" || testPeerFilename != "" {
+		os.Exit(RunOfflineTest(testPeerQuery, testPeerFilename))
+	}
+
+	if replayFilename != "" {
+		os.Exit(RunReplay(replayFilename))
+	}
+
+	if flag.NArg() > 0 {
+		os.Exit(RunControlCommand(flag.Args()))
+	}
+
 	return true
 }
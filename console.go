@@ -21,7 +21,7 @@ type ReleaseStruct struct {
 	TagName    string `json:"tag_name"`
 	Name       string `json:"name"`
 	Body       string `json:"body"`
-	PreRelease bool   `json:"prerelease`
+	PreRelease bool   `json:"prerelease"`
 }
 
 func ProcessVersion(version string) (int, int, int, int, string) {
@@ -91,14 +91,14 @@ func CheckUpdate() {
 		return
 	}
 
-	_, listReleaseContent := Fetch("https://api.github.com/repos/Simple-Tracker/qBittorrent-ClientBlocker/releases?per_page=5", false, false, &githubAPIHeader)
-	if listReleaseContent == nil {
-		Log("CheckUpdate", GetLangText("Error-FetchUpdate"), true)
+	listReleaseResponse, err := DoRequest(RequestStruct { URL: "https://api.github.com/repos/Simple-Tracker/qBittorrent-ClientBlocker/releases?per_page=5", Header: &githubAPIHeader, MaxSize: 8388608 })
+	if err != nil {
+		Log("CheckUpdate", GetLangText("Error-FetchUpdate") + ": %s", true, err.Error())
 		return
 	}
 
 	var releasesStruct []ReleaseStruct
-	if err := json.Unmarshal(listReleaseContent, &releasesStruct); err != nil {
+	if err := json.Unmarshal(listReleaseResponse.Body, &releasesStruct); err != nil {
 		Log("CheckUpdate", GetLangText("Error-Parse"), true, err.Error())
 		return
 	}
@@ -175,7 +175,7 @@ func CheckUpdate() {
 	}
 }
 func Task() {
-	if config.ClientURL == "" {
+	if config.ClientURL == "" && !replayMode {
 		Log("Task", GetLangText("Error-Task_EmptyURL"), true)
 		return
 	}
@@ -188,6 +188,7 @@ func Task() {
 	if torrents == nil {
 		return
 	}
+	defer SaveCapture()
 
 	cleanCount := ClearBlockPeer()
 
@@ -195,6 +196,7 @@ func Task() {
 	noLeechersCount := 0
 	badTorrentInfoCount := 0
 	ptTorrentCount := 0
+	skipTorrentCount := 0
 
 	blockCount := 0
 	ipBlockCount := 0
@@ -205,8 +207,18 @@ func Task() {
 		case "qBittorrent":
 			torrents2 := torrents.(*[]qB_TorrentStruct)
 			for _, torrentInfo := range *torrents2 {
-				ProcessTorrent(torrentInfo.InfoHash, torrentInfo.Tracker, torrentInfo.NumLeechs, torrentInfo.TotalSize, nil, &emptyHashCount, &noLeechersCount, &badTorrentInfoCount, &ptTorrentCount, &blockCount, &ipBlockCount, &badPeersCount, &emptyPeersCount)
+				var torrentTrackers []string
+				var torrentPrivate *bool
+				if replayMode {
+					torrentTrackers, torrentPrivate = ReplayGetTorrentMeta(torrentInfo)
+				} else {
+					torrentTrackers = qB_GetTorrentTrackers(torrentInfo)
+					torrentPrivate = qB_GetTorrentPrivate(torrentInfo)
+					CaptureTorrentMeta(torrentInfo.InfoHash, torrentTrackers, torrentPrivate)
+				}
+				ProcessTorrent(torrentInfo.InfoHash, torrentInfo.Tracker, torrentTrackers, torrentPrivate, torrentInfo.Category, SplitTorrentTags(torrentInfo.Tags), torrentInfo.NumLeechs, torrentInfo.TotalSize, nil, &emptyHashCount, &noLeechersCount, &badTorrentInfoCount, &ptTorrentCount, &skipTorrentCount, &blockCount, &ipBlockCount, &badPeersCount, &emptyPeersCount)
 			}
+			qB_CleanTorrentTrackers()
 		case "Transmission":
 			torrents2 := torrents.(*Tr_TorrentsStruct)
 			for _, torrentInfo := range torrents2.Torrents {
@@ -219,15 +231,23 @@ func Task() {
 				}
 
 				tracker := ""
-				if torrentInfo.Private {
-					tracker = "Private"
+				trackers := []string {}
+				for _, torrentTracker := range torrentInfo.Trackers {
+					trackers = append(trackers, torrentTracker.Announce)
+				}
+				if len(trackers) > 0 {
+					tracker = trackers[0]
 				}
 
-				ProcessTorrent(torrentInfo.InfoHash, tracker, leecherCount, torrentInfo.TotalSize, torrentInfo.Peers, &emptyHashCount, &noLeechersCount, &badTorrentInfoCount, &ptTorrentCount, &blockCount, &ipBlockCount, &badPeersCount, &emptyPeersCount)
+				ProcessTorrent(torrentInfo.InfoHash, tracker, trackers, &torrentInfo.Private, "", torrentInfo.Labels, leecherCount, torrentInfo.TotalSize, torrentInfo.Peers, &emptyHashCount, &noLeechersCount, &badTorrentInfoCount, &ptTorrentCount, &skipTorrentCount, &blockCount, &ipBlockCount, &badPeersCount, &emptyPeersCount)
 			}
+			Tr_CleanPeerEstimate()
 	}
 
+	CheckAllThrottle()
+
 	currentIPBlockCount := CheckAllIP(ipMap, lastIPMap)
+	currentIPBlockCount += CheckAllCorrelation()
 	torrentBlockCount, torrentIPBlockCount := CheckAllTorrent(torrentMap, lastTorrentMap)
 	blockCount += torrentBlockCount
 	ipBlockCount += torrentIPBlockCount
@@ -235,11 +255,12 @@ func Task() {
 	Log("Debug-Task_IgnoreEmptyHashCount", "%d", false, emptyHashCount)
 	Log("Debug-Task_IgnoreNoLeechersCount", "%d", false, noLeechersCount)
 	Log("Debug-Task_IgnorePTTorrentCount", "%d", false, ptTorrentCount)
+	Log("Debug-Task_IgnoreSkipTorrentCount", "%d", false, skipTorrentCount)
 	Log("Debug-Task_IgnoreBadTorrentInfoCount", "%d", false, badTorrentInfoCount)
 	Log("Debug-Task_IgnoreBadPeersCount", "%d", false, badPeersCount)
 	Log("Debug-Task_IgnoreEmptyPeersCount", "%d", false, emptyPeersCount)
 
-	if cleanCount != 0 || blockCount != 0 {
+	if cleanCount != 0 || blockCount != 0 || currentIPBlockCount != 0 {
 		SubmitBlockPeer(blockPeerMap)
 		if !config.IPUploadedCheck && len(ipBlockListCompiled) <= 0 && len(ipBlockListFromURLCompiled) <= 0 {
 			Log("Task", GetLangText("Task_BanInfo"), true, blockCount, len(blockPeerMap))
@@ -284,12 +305,20 @@ func WaitStop() {
 
 	<-signalChan
 		Log("WaitStop", GetLangText("WaitStop_Stoping"), true)
+		// 由主循环恢复原有限速, 以免与 Task 并发访问; 主循环未能及时响应时, 下次启动时根据状态文件恢复.
+		controlCommand := ControlCommandStruct { Name: "restore-throttle", Result: make(chan ControlResultStruct, 1) }
+		select {
+			case controlCommandChan <- controlCommand:
+				<-controlCommand.Result
+			case <-time.After(10 * time.Second):
+		}
 		if loopTicker != nil {
 			loopTicker.Stop()
 		}
 		httpClient.CloseIdleConnections()
 		httpClientWithoutCookie.CloseIdleConnections()
 		StopServer()
+		StopControlServer()
 		Platform_Stop()
 		os.Exit(0)
 }
@@ -304,13 +333,28 @@ func RunConsole() {
 		Log("RunConsole", GetLangText("RunConsole_AuthFailed"), true)
 		os.Exit(1)
 	}
+	LoadThrottleState()
+	StartControlServer()
 	Log("RunConsole", GetLangText("RunConsole_ProgramHasStarted"), true)
 	loopTicker = time.NewTicker(time.Duration(config.Interval) * time.Second)
-	for ; true; <- loopTicker.C {
+	for {
 		currentTimestamp = time.Now().Unix()
 		LoadInitConfig(false)
 		go CheckUpdate()
 		Task()
 		GC()
+		WaitLoop()
+	}
+}
+func WaitLoop() {
+	// 等待下一周期, 期间执行控制命令, 以免与 Task 并发访问全局状态.
+	for {
+		select {
+			case <-loopTicker.C:
+				return
+			case controlCommand := <-controlCommandChan:
+				currentTimestamp = time.Now().Unix()
+				controlCommand.Result <- ExecControlCommand(controlCommand)
+		}
 	}
 }
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"os"
+	"net"
+	"sort"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"strconv"
+	"net/url"
+	"net/http"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+)
+
+type ControlCommandStruct struct {
+	Name   string
+	Args   url.Values
+	Result chan ControlResultStruct
+}
+type ControlResultStruct struct {
+	StatusCode int
+	Data       interface{}
+}
+type ControlBanStruct struct {
+	IP              string           `json:"ip"`
+	Ports           []int            `json:"ports"`
+	InfoHash        string           `json:"infoHash"`
+	Timestamp       int64            `json:"timestamp"`
+	ExpireTimestamp int64            `json:"expireTimestamp"`
+	GeoIP           *GeoIPInfoStruct `json:"geoIP,omitempty"`
+}
+type ControlBanCIDRStruct struct {
+	CIDR            string `json:"cidr"`
+	Timestamp       int64  `json:"timestamp"`
+	ExpireTimestamp int64  `json:"expireTimestamp"`
+}
+type ControlErrorStruct struct {
+	Error string `json:"error"`
+}
+
+// 读取类命令使用 GET, 修改类命令使用 POST.
+var controlCommandMethodMap = map[string]string {
+	"status":    "GET",
+	"list-bans": "GET",
+	"test-peer": "GET",
+	"ban":       "POST",
+	"unban":     "POST",
+	"reload":    "POST",
+}
+var controlCommandChan = make(chan ControlCommandStruct)
+var controlTokenHeader = "X-Control-Token"
+var controlToken = ""
+var controlServer http.Server
+var controlListener net.Listener
+var controlSocketPath = ""
+var startTimestamp = time.Now().Unix()
+
+type controlServerHandler struct {
+}
+
+func (h *controlServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !ProcessControlHTTP(w, r, true) {
+		WriteControlResult(w, ControlResultStruct { StatusCode: 404, Data: ControlErrorStruct { Error: "not found" } })
+	}
+}
+func WriteControlResult(w http.ResponseWriter, controlResult ControlResultStruct) {
+	resultJSON, err := json.Marshal(controlResult.Data)
+	if err != nil {
+		controlResult.StatusCode = 500
+		resultJSON = []byte("{\"error\": \"" + err.Error() + "\"}")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(controlResult.StatusCode)
+	w.Write(resultJSON)
+}
+func IsLoopbackRemoteAddr(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return (ip != nil && ip.IsLoopback())
+}
+func IsLoopbackHost(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.Trim(host, "[]")
+
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return (ip != nil && ip.IsLoopback())
+}
+func GetControlTokenFilename(cfgFilename string) string {
+	// Token 文件位于配置文件所在目录, 每个安装各自生成.
+	return filepath.Join(filepath.Dir(cfgFilename), "control.token")
+}
+func LoadControlToken(create bool) string {
+	controlTokenFilename := GetControlTokenFilename(configFilename)
+
+	controlTokenFile, err := os.ReadFile(controlTokenFilename)
+	if err == nil {
+		return StrTrim(string(controlTokenFile))
+	}
+	if !os.IsNotExist(err) {
+		Log("LoadControlToken", GetLangText("Error-LoadControlToken"), true, controlTokenFilename, err.Error())
+		return ""
+	}
+	if !create {
+		return ""
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		Log("LoadControlToken", GetLangText("Error-LoadControlToken"), true, controlTokenFilename, err.Error())
+		return ""
+	}
+
+	token := hex.EncodeToString(tokenBytes)
+	if err := os.WriteFile(controlTokenFilename, []byte(token + "\n"), 0600); err != nil {
+		Log("LoadControlToken", GetLangText("Error-LoadControlToken"), true, controlTokenFilename, err.Error())
+		return ""
+	}
+
+	return token
+}
+func GetControlTimeout() time.Duration {
+	// 命令需等待主循环执行, 因此至少允许等待两个周期, 而非沿用 httpServer 的写入超时.
+	return (time.Duration(config.Interval * 2 + config.Timeout) * time.Second)
+}
+func ProcessControlHTTP(w http.ResponseWriter, r *http.Request, trusted bool) bool {
+	path := strings.SplitN(r.RequestURI, "?", 2)[0]
+	if !strings.HasPrefix(path, "/api/") {
+		return false
+	}
+
+	// 监听地址可能对外开放, 因此需启用 controlListen, 且仅允许本机访问控制接口, 并校验 Host 以防止 DNS 重绑定; Unix Socket 由文件权限控制.
+	if !trusted {
+		if !config.ControlListen {
+			return false
+		}
+		if !IsLoopbackRemoteAddr(r.RemoteAddr) || !IsLoopbackHost(r.Host) {
+			WriteControlResult(w, ControlResultStruct { StatusCode: 403, Data: ControlErrorStruct { Error: "forbidden" } })
+			return true
+		}
+	}
+
+	commandName := path[5:]
+	commandMethod, exist := controlCommandMethodMap[commandName]
+	if !exist {
+		WriteControlResult(w, ControlResultStruct { StatusCode: 404, Data: ControlErrorStruct { Error: "unknown command: " + commandName } })
+		return true
+	}
+	if r.Method != commandMethod {
+		WriteControlResult(w, ControlResultStruct { StatusCode: 405, Data: ControlErrorStruct { Error: "method not allowed" } })
+		return true
+	}
+
+	// 修改类命令须附带 Token, 以免网页通过浏览器发起跨站请求.
+	if !trusted && commandMethod == "POST" && (controlToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(controlTokenHeader)), []byte(controlToken)) != 1) {
+		WriteControlResult(w, ControlResultStruct { StatusCode: 403, Data: ControlErrorStruct { Error: "bad token" } })
+		return true
+	}
+
+	if err := r.ParseForm(); err != nil {
+		WriteControlResult(w, ControlResultStruct { StatusCode: 400, Data: ControlErrorStruct { Error: err.Error() } })
+		return true
+	}
+
+	controlTimeout := GetControlTimeout()
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(controlTimeout))
+	controlTimer := time.NewTimer(controlTimeout)
+	defer controlTimer.Stop()
+
+	// 命令由主循环执行, 以免与 Task 并发访问全局状态.
+	controlCommand := ControlCommandStruct { Name: commandName, Args: r.Form, Result: make(chan ControlResultStruct, 1) }
+	select {
+		case controlCommandChan <- controlCommand:
+		case <-controlTimer.C:
+			WriteControlResult(w, ControlResultStruct { StatusCode: 503, Data: ControlErrorStruct { Error: "timeout" } })
+			return true
+		case <-r.Context().Done():
+			return true
+	}
+	select {
+		case controlResult := <-controlCommand.Result:
+			WriteControlResult(w, controlResult)
+		case <-controlTimer.C:
+			WriteControlResult(w, ControlResultStruct { StatusCode: 503, Data: ControlErrorStruct { Error: "timeout" } })
+		case <-r.Context().Done():
+	}
+
+	return true
+}
+func ExecControlCommand(controlCommand ControlCommandStruct) ControlResultStruct {
+	Log("Debug-ExecControlCommand", "%s %s", false, controlCommand.Name, controlCommand.Args.Encode())
+
+	var data interface{}
+	var err error
+
+	switch controlCommand.Name {
+		case "status":
+			data = GetControlStatus()
+		case "list-bans":
+			data = GetControlBans()
+		case "ban":
+			data, err = ControlBan(controlCommand.Args.Get("ip"), controlCommand.Args.Get("duration"))
+		case "unban":
+			data, err = ControlUnban(controlCommand.Args.Get("ip"))
+		case "reload":
+			configLastMod = 0
+			additionConfigLastMod = 0
+			LoadInitConfig(false)
+			data = GetControlStatus()
+		case "test-peer":
+			testPeer := TestPeerStruct {}
+			for key := range controlCommand.Args {
+				if err = SetTestPeerField(&testPeer, key, controlCommand.Args.Get(key)); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				data = TestPeer(testPeer)
+			}
+		case "restore-throttle":
+			// 仅供程序退出时使用, 不对外提供.
+			RestoreAllThrottle()
+	}
+
+	if err != nil {
+		return ControlResultStruct { StatusCode: 400, Data: ControlErrorStruct { Error: err.Error() } }
+	}
+
+	return ControlResultStruct { StatusCode: 200, Data: data }
+}
+func GetControlStatus() map[string]interface{} {
+	return map[string]interface{} {
+		"version":          programVersion,
+		"clientType":       currentClientType,
+		"clientURL":        config.ClientURL,
+		"interval":         config.Interval,
+		"startTimestamp":   startTimestamp,
+		"currentTimestamp": currentTimestamp,
+		"banCount":         len(blockPeerMap),
+		"banCIDRCount":     len(blockCIDRMap),
+		"torrentCount":     len(torrentMap),
+		"throttleCount":    len(throttleTorrentMap),
+	}
+}
+func GetControlBans() map[string]interface{} {
+	bans := []ControlBanStruct {}
+	for peerIP, peerInfo := range blockPeerMap {
+		banTime := int64(config.BanTime)
+		if peerInfo.BanTime > 0 {
+			banTime = peerInfo.BanTime
+		}
+
+		ports := []int {}
+		for port := range peerInfo.Port {
+			ports = append(ports, port)
+		}
+		sort.Ints(ports)
+
+		bans = append(bans, ControlBanStruct { IP: peerIP, Ports: ports, InfoHash: peerInfo.InfoHash, Timestamp: peerInfo.Timestamp, ExpireTimestamp: (peerInfo.Timestamp + banTime), GeoIP: peerInfo.GeoIP })
+	}
+	sort.Slice(bans, func (i, j int) bool {
+		return bans[i].IP < bans[j].IP
+	})
+
+	banCIDRs := []ControlBanCIDRStruct {}
+	for cidrStr, blockCIDRInfo := range blockCIDRMap {
+		expireTimestamp := int64(0)
+		if blockCIDRInfo.BanTime > 0 {
+			expireTimestamp = (blockCIDRInfo.Timestamp + blockCIDRInfo.BanTime)
+		}
+		banCIDRs = append(banCIDRs, ControlBanCIDRStruct { CIDR: cidrStr, Timestamp: blockCIDRInfo.Timestamp, ExpireTimestamp: expireTimestamp })
+	}
+	sort.Slice(banCIDRs, func (i, j int) bool {
+		return banCIDRs[i].CIDR < banCIDRs[j].CIDR
+	})
+
+	return map[string]interface{} { "bans": bans, "cidrs": banCIDRs }
+}
+func ParseControlDuration(durationStr string) (int64, error) {
+	// 支持秒数及 Go 时长格式 (如 90m, 24h), 为空时使用 config.BanTime.
+	if durationStr == "" {
+		return 0, nil
+	}
+
+	if duration, err := strconv.ParseInt(durationStr, 10, 64); err == nil && duration > 0 {
+		return duration, nil
+	}
+
+	duration, err := time.ParseDuration(durationStr)
+	if err != nil || duration < time.Second {
+		return 0, errors.New("bad duration: " + durationStr)
+	}
+
+	return int64(duration / time.Second), nil
+}
+func ControlBan(peerIP string, durationStr string) (map[string]interface{}, error) {
+	banTime, err := ParseControlDuration(durationStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.Contains(peerIP, "/") {
+		_, peerNet, err := net.ParseCIDR(peerIP)
+		if err != nil {
+			return nil, errors.New("bad IP or CIDR: " + peerIP)
+		}
+		if banTime <= 0 {
+			banTime = int64(config.BanTime)
+		}
+		blockCIDRMap[peerNet.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: peerNet, BanTime: banTime }
+		peerIP = peerNet.String()
+	} else {
+		if net.ParseIP(peerIP) == nil {
+			return nil, errors.New("bad IP or CIDR: " + peerIP)
+		}
+		peerIP = ProcessIP(peerIP)
+		AddBlockPeerWithBanTime(peerIP, -1, "", banTime)
+	}
+
+	Log("ControlBan", GetLangText("Success-ControlBan"), true, peerIP, banTime)
+	SubmitBlockPeer(blockPeerMap)
+
+	return map[string]interface{} { "ip": peerIP, "banTime": banTime }, nil
+}
+func ControlUnban(peerIP string) (map[string]interface{}, error) {
+	unbanIPs := []string {}
+	unbanCIDRs := []string {}
+	keptCIDRs := []string {}
+
+	if strings.Contains(peerIP, "/") {
+		_, peerNet, err := net.ParseCIDR(peerIP)
+		if err != nil {
+			return nil, errors.New("bad IP or CIDR: " + peerIP)
+		}
+		if _, exist := blockCIDRMap[peerNet.String()]; exist {
+			delete(blockCIDRMap, peerNet.String())
+			unbanCIDRs = append(unbanCIDRs, peerNet.String())
+		}
+		for blockPeerIP := range blockPeerMap {
+			if ip := net.ParseIP(blockPeerIP); ip != nil && peerNet.Contains(ip) {
+				unbanIPs = append(unbanIPs, blockPeerIP)
+			}
+		}
+	} else {
+		if net.ParseIP(peerIP) == nil {
+			return nil, errors.New("bad IP or CIDR: " + peerIP)
+		}
+		peerIP = ProcessIP(peerIP)
+		if _, exist := blockPeerMap[peerIP]; exist {
+			unbanIPs = append(unbanIPs, peerIP)
+		}
+		// 同时移除封禁该 IP 时按 banIPCIDR 记录的 IP 段, 但段内仍有其它被封禁的 IP 时保留.
+		if peerNet := ParseIPCIDRByConfig(peerIP); peerNet != nil {
+			if _, exist := blockCIDRMap[peerNet.String()]; exist {
+				otherBlocked := false
+				for blockPeerIP := range blockPeerMap {
+					if ip := net.ParseIP(blockPeerIP); blockPeerIP != peerIP && ip != nil && peerNet.Contains(ip) {
+						otherBlocked = true
+						break
+					}
+				}
+				if !otherBlocked {
+					delete(blockCIDRMap, peerNet.String())
+					unbanCIDRs = append(unbanCIDRs, peerNet.String())
+				}
+			}
+		}
+		// 其它包含该 IP 的 IP 段 (如 IP 段升级, 关联分析或规则产生的) 不会被移除, 因此一并返回.
+		ip := net.ParseIP(peerIP)
+		for cidr, blockCIDRInfo := range blockCIDRMap {
+			if blockCIDRInfo.Net != nil && blockCIDRInfo.Net.Contains(ip) {
+				keptCIDRs = append(keptCIDRs, cidr)
+			}
+		}
+		sort.Strings(keptCIDRs)
+	}
+
+	for _, unbanIP := range unbanIPs {
+		peerInfo := blockPeerMap[unbanIP]
+		delete(blockPeerMap, unbanIP)
+		ExecCommandUnban(unbanIP, peerInfo)
+	}
+
+	if len(unbanIPs) <= 0 && len(unbanCIDRs) <= 0 {
+		if len(keptCIDRs) > 0 {
+			return nil, errors.New("banned by IP range: " + strings.Join(keptCIDRs, ", "))
+		}
+		return nil, errors.New("not banned: " + peerIP)
+	}
+
+	Log("ControlUnban", GetLangText("Success-ControlUnban"), true, peerIP, len(unbanIPs), len(unbanCIDRs))
+	if len(keptCIDRs) > 0 {
+		Log("ControlUnban", GetLangText("Warning-ControlUnban_KeptCIDR"), true, peerIP, strings.Join(keptCIDRs, ", "))
+	}
+	SubmitBlockPeer(blockPeerMap)
+
+	return map[string]interface{} { "ips": unbanIPs, "cidrs": unbanCIDRs, "keptCIDRs": keptCIDRs }, nil
+}
+func StartControlServer() {
+	if config.ControlSocket == "" || controlListener != nil {
+		return
+	}
+
+	// 移除上次异常退出时残留的 Socket 文件.
+	os.Remove(config.ControlSocket)
+
+	listener, err := net.Listen("unix", config.ControlSocket)
+	if err != nil {
+		Log("StartControlServer", GetLangText("Error-StartServer_Listen"), true, err.Error())
+		return
+	}
+
+	if err := os.Chmod(config.ControlSocket, 0600); err != nil {
+		Log("StartControlServer", GetLangText("Error-StartServer_Listen"), true, err.Error())
+	}
+
+	controlListener = listener
+	controlSocketPath = config.ControlSocket
+	// 命令需等待主循环执行, 写入超时由 ProcessControlHTTP 单独设置.
+	controlServer = http.Server {
+		ReadTimeout: httpServerTimeout,
+		Handler:     &controlServerHandler {},
+	}
+
+	go func(controlServer *http.Server, listener net.Listener) {
+		if err := controlServer.Serve(listener); err != http.ErrServerClosed {
+			Log("StartControlServer", GetLangText("Error-StartServer_Serve"), true, err.Error())
+		}
+	}(&controlServer, listener)
+}
+func StopControlServer() {
+	if controlListener == nil {
+		return
+	}
+
+	if err := controlServer.Shutdown(context.Background()); err != nil {
+		Log("StopControlServer", GetLangText("Error-StopServer"), true, err.Error())
+	}
+
+	controlListener.Close()
+	os.Remove(controlSocketPath)
+
+	controlListener = nil
+	controlSocketPath = ""
+}
+func GetControlURL(cfg ConfigStruct) (string, *http.Client, error) {
+	// 优先使用 Unix Socket, 否则连接本机的监听地址.
+	if cfg.ControlSocket != "" {
+		controlSocket := cfg.ControlSocket
+		controlClient := &http.Client {
+			Transport: &http.Transport {
+				DialContext: func (ctx context.Context, _ string, _ string) (net.Conn, error) {
+					return (&net.Dialer {}).DialContext(ctx, "unix", controlSocket)
+				},
+			},
+		}
+		return "http://unix/api/", controlClient, nil
+	}
+
+	if cfg.Listen == "" || !cfg.ControlListen {
+		return "", nil, errors.New("neither controlSocket nor controlListen is set")
+	}
+
+	host, port, err := net.SplitHostPort(strings.SplitN(cfg.Listen, "/", 2)[0])
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch host {
+		case "", "0.0.0.0":
+			host = "127.0.0.1"
+		case "::":
+			host = "::1"
+		default:
+			// 控制接口仅接受本机地址的连接, 而监听于其它地址时本机地址上并无监听, 因此无法使用.
+			if !IsLoopbackHost(host) {
+				return "", nil, errors.New("listen " + host + " is not a loopback or unspecified address, the control API over listen is unreachable; set controlSocket instead")
+			}
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + "/api/", &http.Client {}, nil
+}
+func RunControlCommand(args []string) int {
+	// 结果以 JSON 输出至标准输出, 便于脚本处理.
+	commandName := args[0]
+	commandMethod, exist := controlCommandMethodMap[commandName]
+	if !exist {
+		Log("RunControlCommand", GetLangText("Error-ControlCommandUsage"), false, commandName)
+		return 2
+	}
+
+	commandArgs := url.Values {}
+	switch commandName {
+		case "ban", "unban":
+			if len(args) < 2 {
+				Log("RunControlCommand", GetLangText("Error-ControlCommandUsage"), false, commandName)
+				return 2
+			}
+			commandArgs.Set("ip", args[1])
+			if commandName == "ban" && len(args) >= 3 {
+				commandArgs.Set("duration", args[2])
+			}
+		case "test-peer":
+			// test-peer <ip> [port] [key=value ...]
+			for k, arg := range args[1:] {
+				if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 {
+					commandArgs.Set(kv[0], kv[1])
+				} else if k == 0 {
+					commandArgs.Set("ip", arg)
+				} else if k == 1 {
+					commandArgs.Set("port", arg)
+				}
+			}
+	}
+
+	checkedConfig := DeepCopyConfig(defaultConfig)
+	for _, filename := range []string { configFilename, additionConfigFilename } {
+		if configFile, err := os.ReadFile(filename); err == nil {
+			if configJSON, err := ConvertConfigToJSON(filename, configFile); err == nil {
+				json.Unmarshal(configJSON, &checkedConfig)
+			}
+		}
+	}
+	ApplyEnvConfig(&checkedConfig)
+
+	controlURL, controlClient, err := GetControlURL(checkedConfig)
+	if err != nil {
+		Log("RunControlCommand", GetLangText("Error-RunControlCommand"), false, err.Error())
+		return 1
+	}
+
+	var request *http.Request
+	if commandMethod == "POST" {
+		request, err = http.NewRequest("POST", controlURL + commandName, strings.NewReader(commandArgs.Encode()))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		request, err = http.NewRequest("GET", controlURL + commandName + "?" + commandArgs.Encode(), nil)
+	}
+	if err != nil {
+		Log("RunControlCommand", GetLangText("Error-RunControlCommand"), false, err.Error())
+		return 1
+	}
+
+	// 通过监听地址执行修改类命令时, 须附带运行中实例生成的 Token.
+	if token := LoadControlToken(false); token != "" {
+		request.Header.Set(controlTokenHeader, token)
+	}
+
+	response, err := controlClient.Do(request)
+	if err != nil {
+		Log("RunControlCommand", GetLangText("Error-RunControlCommand"), false, err.Error())
+		return 1
+	}
+	defer response.Body.Close()
+
+	var result interface{}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		Log("RunControlCommand", GetLangText("Error-RunControlCommand"), false, err.Error())
+		return 1
+	}
+
+	resultJSON, _ := json.MarshalIndent(result, "", "\t")
+	os.Stdout.Write(append(resultJSON, '\n'))
+
+	if response.StatusCode != 200 {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"strings"
+	"net/http/httptest"
+)
+
+func TestProcessControlHTTP(t *testing.T) {
+	lastControlListen := config.ControlListen
+	lastControlToken := controlToken
+	config.ControlListen = true
+	controlToken = "0123456789abcdef"
+	defer func() {
+		config.ControlListen = lastControlListen
+		controlToken = lastControlToken
+	}()
+
+	// 模拟主循环执行命令.
+	stopChan := make(chan bool)
+	defer close(stopChan)
+	go func() {
+		for {
+			select {
+				case controlCommand := <-controlCommandChan:
+					controlCommand.Result <- ControlResultStruct { StatusCode: 200, Data: map[string]interface{} {} }
+				case <-stopChan:
+					return
+			}
+		}
+	}()
+
+	testCases := []struct {
+		method     string
+		path       string
+		remoteAddr string
+		host       string
+		token      string
+		trusted    bool
+		statusCode int
+	} {
+		{ "GET", "/api/status", "127.0.0.1:40000", "127.0.0.1:26262", "", false, 200 },
+		{ "GET", "/api/status", "[::1]:40000", "localhost:26262", "", false, 200 },
+		{ "GET", "/api/status", "192.0.2.1:40000", "127.0.0.1:26262", "", false, 403 },
+		{ "GET", "/api/status", "127.0.0.1:40000", "evil.example.com:26262", "", false, 403 },
+		{ "POST", "/api/reload", "127.0.0.1:40000", "127.0.0.1:26262", "", false, 403 },
+		{ "POST", "/api/reload", "127.0.0.1:40000", "127.0.0.1:26262", "bad", false, 403 },
+		{ "POST", "/api/reload", "127.0.0.1:40000", "127.0.0.1:26262", "0123456789abcdef", false, 200 },
+		{ "POST", "/api/reload", "", "unix", "", true, 200 },
+		{ "GET", "/api/reload", "127.0.0.1:40000", "127.0.0.1:26262", "0123456789abcdef", false, 405 },
+		{ "GET", "/api/unknown", "127.0.0.1:40000", "127.0.0.1:26262", "", false, 404 },
+	}
+
+	for _, testCase := range testCases {
+		r := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader(""))
+		r.RemoteAddr = testCase.remoteAddr
+		r.Host = testCase.host
+		if testCase.token != "" {
+			r.Header.Set(controlTokenHeader, testCase.token)
+		}
+		w := httptest.NewRecorder()
+
+		if !ProcessControlHTTP(w, r, testCase.trusted) {
+			t.Errorf("%s %s (Host: %s): not processed", testCase.method, testCase.path, testCase.host)
+			continue
+		}
+		if w.Code != testCase.statusCode {
+			t.Errorf("%s %s (RemoteAddr: %s, Host: %s, Token: %q) = %d, want %d", testCase.method, testCase.path, testCase.remoteAddr, testCase.host, testCase.token, w.Code, testCase.statusCode)
+		}
+	}
+
+	// 未启用 controlListen 时, 监听地址不提供控制接口.
+	config.ControlListen = false
+	r := httptest.NewRequest("GET", "/api/status", nil)
+	r.RemoteAddr = "127.0.0.1:40000"
+	if ProcessControlHTTP(httptest.NewRecorder(), r, false) {
+		t.Error("ProcessControlHTTP without controlListen: expected not processed")
+	}
+}
+func TestControlUnban(t *testing.T) {
+	lastConfig := config
+	lastClientType := currentClientType
+	lastBlockPeerMap := blockPeerMap
+	lastBlockCIDRMap := blockCIDRMap
+	lastCIDRPromotionMap := cidrPromotionMap
+	lastTimestamp := currentTimestamp
+	defer func() {
+		config = lastConfig
+		currentClientType = lastClientType
+		blockPeerMap = lastBlockPeerMap
+		blockCIDRMap = lastBlockCIDRMap
+		cidrPromotionMap = lastCIDRPromotionMap
+		currentTimestamp = lastTimestamp
+	}()
+
+	currentClientType = ""
+	currentTimestamp = 1700000000
+	config.BanIPCIDR = "/24"
+	config.CIDRPromotionCount = 0
+	blockPeerMap = make(map[string]BlockPeerInfoStruct)
+	blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
+	cidrPromotionMap = make(map[string]int64)
+
+	AddBlockPeer("203.0.113.7", 6881, "")
+	AddBlockPeer("203.0.113.8", 6881, "")
+
+	// 段内仍有其它被封禁的 IP 时, 应保留按 banIPCIDR 记录的 IP 段.
+	result, err := ControlUnban("203.0.113.7")
+	if err != nil {
+		t.Fatalf("ControlUnban: %v", err)
+	}
+	if _, exist := blockCIDRMap["203.0.113.0/24"]; !exist || len(result["cidrs"].([]string)) != 0 {
+		t.Fatalf("ControlUnban: removed 203.0.113.0/24 while 203.0.113.8 is banned (%v)", result)
+	}
+	if keptCIDRs := result["keptCIDRs"].([]string); len(keptCIDRs) != 1 || keptCIDRs[0] != "203.0.113.0/24" {
+		t.Errorf("ControlUnban: keptCIDRs = %v, want [203.0.113.0/24]", keptCIDRs)
+	}
+
+	// 最后一个 IP 解除封禁时, 一并移除 IP 段.
+	if result, err = ControlUnban("203.0.113.8"); err != nil {
+		t.Fatalf("ControlUnban: %v", err)
+	}
+	if _, exist := blockCIDRMap["203.0.113.0/24"]; exist || len(result["keptCIDRs"].([]string)) != 0 {
+		t.Errorf("ControlUnban: 203.0.113.0/24 was not removed (%v)", result)
+	}
+
+	// 仅被其它 IP 段 (如 IP 段升级) 封禁时, 应报告该 IP 段.
+	config.BanIPCIDR = "/32"
+	_, promotedNet, _ := net.ParseCIDR("198.51.100.0/24")
+	blockCIDRMap[promotedNet.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: promotedNet }
+	if _, err := ControlUnban("198.51.100.9"); err == nil || !strings.Contains(err.Error(), "198.51.100.0/24") {
+		t.Errorf("ControlUnban: err = %v, want promoted range reported", err)
+	}
+}
+func TestGetControlURL(t *testing.T) {
+	testCases := []struct {
+		listen     string
+		controlURL string
+	} {
+		{ ":26262", "http://127.0.0.1:26262/api/" },
+		{ "0.0.0.0:26262", "http://127.0.0.1:26262/api/" },
+		{ "[::]:26262", "http://[::1]:26262/api/" },
+		{ "127.0.0.1:26262", "http://127.0.0.1:26262/api/" },
+		{ "localhost:26262", "http://localhost:26262/api/" },
+		{ "192.168.1.10:26262", "" },
+	}
+
+	for _, testCase := range testCases {
+		controlURL, _, err := GetControlURL(ConfigStruct { Listen: testCase.listen, ControlListen: true })
+		if testCase.controlURL == "" {
+			if err == nil || !strings.Contains(err.Error(), "controlSocket") {
+				t.Errorf("GetControlURL(%s): err = %v, want controlSocket hint", testCase.listen, err)
+			}
+			continue
+		}
+		if err != nil || controlURL != testCase.controlURL {
+			t.Errorf("GetControlURL(%s) = %q, %v, want %q", testCase.listen, controlURL, err, testCase.controlURL)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"errors"
+	"reflect"
+	"strings"
+	"strconv"
+	"encoding/json"
+)
+
+var envConfigPrefix = "QBCB_"
+
+func GetEnvConfigField(schema reflect.Type, envKey string, ignoreUnderscore bool) (reflect.StructField, bool) {
+	// 键名大小写不敏感. 带前缀时亦忽略下划线, 如 QBCB_DEBUG_CHECKTORRENT 对应 Debug_CheckTorrent.
+	for k := 0; k < schema.NumField(); k++ {
+		field := schema.Field(k)
+		if strings.EqualFold(field.Name, envKey) || (ignoreUnderscore && strings.EqualFold(strings.ReplaceAll(field.Name, "_", ""), strings.ReplaceAll(envKey, "_", ""))) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField {}, false
+}
+func ParseEnvConfigValue(fieldValue reflect.Value, envValue string) error {
+	// 自定义解析的类型 (如 portBlockList) 亦允许省略 JSON 字符串的引号.
+	if reflect.PtrTo(fieldValue.Type()).Implements(jsonUnmarshalerType) {
+		if err := json.Unmarshal([]byte(envValue), fieldValue.Addr().Interface()); err != nil {
+			envValueJSON, _ := json.Marshal(envValue)
+			return json.Unmarshal(envValueJSON, fieldValue.Addr().Interface())
+		}
+		return nil
+	}
+
+	switch fieldValue.Kind() {
+		case reflect.String:
+			fieldValue.SetString(envValue)
+		case reflect.Bool:
+			value, err := strconv.ParseBool(StrTrim(envValue))
+			if err != nil {
+				return err
+			}
+			fieldValue.SetBool(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			value, err := strconv.ParseInt(StrTrim(envValue), 10, fieldValue.Type().Bits())
+			if err != nil {
+				return err
+			}
+			fieldValue.SetInt(value)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			value, err := strconv.ParseUint(StrTrim(envValue), 10, fieldValue.Type().Bits())
+			if err != nil {
+				return err
+			}
+			fieldValue.SetUint(value)
+		case reflect.Float32, reflect.Float64:
+			value, err := strconv.ParseFloat(StrTrim(envValue), fieldValue.Type().Bits())
+			if err != nil {
+				return err
+			}
+			fieldValue.SetFloat(value)
+		case reflect.Slice:
+			// 以 [ 开头时视为 JSON 数组, 否则以逗号分隔.
+			envValue = StrTrim(envValue)
+			if strings.HasPrefix(envValue, "[") {
+				return json.Unmarshal([]byte(envValue), fieldValue.Addr().Interface())
+			}
+
+			values := reflect.MakeSlice(fieldValue.Type(), 0, 0)
+			for _, value := range strings.Split(envValue, ",") {
+				if value = StrTrim(value); value == "" {
+					continue
+				}
+
+				elemValue := reflect.New(fieldValue.Type().Elem()).Elem()
+				if err := ParseEnvConfigValue(elemValue, value); err != nil {
+					return err
+				}
+				values = reflect.Append(values, elemValue)
+			}
+			fieldValue.Set(values)
+		default:
+			return json.Unmarshal([]byte(envValue), fieldValue.Addr().Interface())
+	}
+
+	return nil
+}
+func ApplyEnvConfig(cfg *ConfigStruct) error {
+	configValue := reflect.ValueOf(cfg).Elem()
+	configType := configValue.Type()
+
+	// 兼容旧版 entrypoint.sh: 设置 useENV 时, 与配置项同名的环境变量亦生效, 但优先级低于带前缀的环境变量.
+	legacyEnvList := []string {}
+	prefixEnvList := []string {}
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(strings.ToUpper(env), envConfigPrefix) {
+			prefixEnvList = append(prefixEnvList, env)
+		} else if os.Getenv("useENV") != "" {
+			legacyEnvList = append(legacyEnvList, env)
+		}
+	}
+
+	for _, env := range append(legacyEnvList, prefixEnvList...) {
+		envKV := strings.SplitN(env, "=", 2)
+		if len(envKV) != 2 {
+			continue
+		}
+
+		envKey := envKV[0]
+		isPrefixEnv := strings.HasPrefix(strings.ToUpper(envKey), envConfigPrefix)
+		if isPrefixEnv {
+			envKey = envKey[len(envConfigPrefix):]
+		}
+
+		field, exist := GetEnvConfigField(configType, envKey, isPrefixEnv)
+		if !exist {
+			if isPrefixEnv {
+				Log("ApplyEnvConfig", GetLangText("Warning-EnvConfigUnknown"), true, envKV[0])
+			}
+			continue
+		}
+
+		if err := ParseEnvConfigValue(configValue.FieldByIndex(field.Index), envKV[1]); err != nil {
+			return errors.New(envKV[0] + ": " + err.Error())
+		}
+
+		Log("Debug-ApplyEnvConfig", "%s -> %s", false, envKV[0], field.Name)
+	}
+
+	return nil
+}
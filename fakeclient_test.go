@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+	"strings"
+	"strconv"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	"crypto/subtle"
+	"encoding/json"
+)
+
+// 模拟客户端, 实现本程序所使用的 qBittorrent Web API 及 Transmission RPC 的子集.
+// 其数据来自 testdata 中的 Fixture (与 --capture 记录的单个周期格式相同), 并记录收到的封禁请求.
+type FakeClientStruct struct {
+	ClientType    string
+	Username      string
+	Password      string
+	Snapshot      CaptureSnapshotStruct
+	Server        *httptest.Server
+	mutex         sync.Mutex
+	sessionID     string
+	LoginCount    int
+	CSRFCount     int
+	BanPeersBody  []string
+	RPCBody       []string
+	BlocklistURL  string
+	Blocklist     string
+}
+type FakeClientRPCRequestStruct struct {
+	Method string          `json:"method"`
+	Args   json.RawMessage `json:"arguments"`
+}
+
+func StartFakeClient(t *testing.T, clientType string) *FakeClientStruct {
+	snapshotJSON, err := os.ReadFile("testdata/FakeClient-" + clientType + ".json")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	f := &FakeClientStruct { ClientType: clientType, Username: "admin", Password: "adminadmin" }
+	if err := json.Unmarshal(snapshotJSON, &f.Snapshot); err != nil {
+		t.Fatalf("Unmarshal fixture: %v", err)
+	}
+
+	f.Server = httptest.NewServer(f)
+	t.Cleanup(f.Server.Close)
+
+	return f
+}
+func (f *FakeClientStruct) URL() string {
+	if f.ClientType == "Transmission" {
+		return f.Server.URL + "/transmission/rpc"
+	}
+
+	return f.Server.URL
+}
+func (f *FakeClientStruct) ExpireSession() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.sessionID = ""
+}
+func (f *FakeClientStruct) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch f.ClientType {
+		case "qBittorrent":
+			f.qB_ServeHTTP(w, r)
+		case "Transmission":
+			f.Tr_ServeHTTP(w, r)
+		default:
+			w.WriteHeader(404)
+	}
+}
+func (f *FakeClientStruct) CheckCredential(username string, password string) bool {
+	return (subtle.ConstantTimeCompare([]byte(username), []byte(f.Username)) == 1 && subtle.ConstantTimeCompare([]byte(password), []byte(f.Password)) == 1)
+}
+func (f *FakeClientStruct) WriteJSON(w http.ResponseWriter, v interface{}) {
+	responseJSON, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Write(responseJSON)
+}
+func (f *FakeClientStruct) qB_ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// webapiVersion 及 auth/login 无需登录, 其余接口未登录时返回 403, 与 qBittorrent 一致.
+	switch r.URL.Path {
+		case "/api/v2/app/webapiVersion":
+			w.Write([]byte("2.8.3"))
+			return
+		case "/api/v2/auth/login":
+			if r.Method != "POST" || !f.CheckCredential(r.PostFormValue("username"), r.PostFormValue("password")) {
+				w.Write([]byte("Fails."))
+				return
+			}
+			f.LoginCount++
+			f.sessionID = "fake-sid-" + strconv.Itoa(f.LoginCount)
+			http.SetCookie(w, &http.Cookie { Name: "SID", Value: f.sessionID, Path: "/" })
+			w.Write([]byte("Ok."))
+			return
+	}
+
+	if sessionCookie, err := r.Cookie("SID"); err != nil || f.sessionID == "" || sessionCookie.Value != f.sessionID {
+		w.WriteHeader(403)
+		w.Write([]byte("Forbidden"))
+		return
+	}
+
+	infoHash := strings.ToLower(r.URL.Query().Get("hash"))
+
+	switch r.URL.Path {
+		case "/api/v2/torrents/info":
+			w.Write(f.Snapshot.Torrents)
+		case "/api/v2/sync/torrentPeers":
+			if torrentPeersJSON, exist := f.Snapshot.Peers[infoHash]; exist {
+				w.Write(torrentPeersJSON)
+				return
+			}
+			w.Write([]byte("{\"full_update\": true, \"peers\": {}}"))
+		case "/api/v2/torrents/trackers":
+			torrentTrackers := []qB_TorrentTrackerStruct {}
+			for _, torrentTracker := range f.Snapshot.Trackers[infoHash] {
+				torrentTrackers = append(torrentTrackers, qB_TorrentTrackerStruct { URL: torrentTracker })
+			}
+			f.WriteJSON(w, torrentTrackers)
+		case "/api/v2/torrents/properties":
+			f.WriteJSON(w, qB_TorrentPropertiesStruct { IsPrivate: f.Snapshot.Private[infoHash] })
+		case "/api/v2/transfer/banPeers":
+			banPeersBody, _ := io.ReadAll(r.Body)
+			f.BanPeersBody = append(f.BanPeersBody, string(banPeersBody))
+		default:
+			w.WriteHeader(404)
+	}
+}
+func (f *FakeClientStruct) Tr_WriteResponse(w http.ResponseWriter, result string, args interface{}) {
+	f.WriteJSON(w, map[string]interface{} { "result": result, "arguments": args })
+}
+func (f *FakeClientStruct) Tr_ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/transmission/rpc" {
+		w.WriteHeader(404)
+		return
+	}
+
+	// 认证先于 CSRF 检查, 与 Transmission 一致.
+	if username, password, ok := r.BasicAuth(); !ok || !f.CheckCredential(username, password) {
+		w.WriteHeader(401)
+		return
+	}
+
+	if f.sessionID == "" {
+		f.LoginCount++
+		f.sessionID = "fake-session-" + strconv.Itoa(f.LoginCount)
+	}
+	if r.Header.Get("X-Transmission-Session-Id") != f.sessionID {
+		f.CSRFCount++
+		w.Header().Set("X-Transmission-Session-Id", f.sessionID)
+		w.WriteHeader(409)
+		return
+	}
+
+	rpcBody, _ := io.ReadAll(r.Body)
+	f.RPCBody = append(f.RPCBody, string(rpcBody))
+
+	var rpcRequest FakeClientRPCRequestStruct
+	if err := json.Unmarshal(rpcBody, &rpcRequest); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	switch rpcRequest.Method {
+		case "session-get":
+			f.Tr_WriteResponse(w, "success", map[string]interface{} { "version": "4.0.5 (fake)", "rpc-version": 17 })
+		case "torrent-get":
+			f.Tr_WriteResponse(w, "success", f.Snapshot.Torrents)
+		case "session-set":
+			var sessionSet Tr_SessionSetStruct
+			if err := json.Unmarshal(rpcRequest.Args, &sessionSet); err != nil {
+				f.Tr_WriteResponse(w, err.Error(), nil)
+				return
+			}
+			f.BlocklistURL = sessionSet.BlocklistURL
+			f.Tr_WriteResponse(w, "success", struct {} {})
+		case "blocklist-update":
+			// 与 Transmission 相同, 从 blocklist-url 下载列表.
+			blocklistResponse, err := http.Get(f.BlocklistURL)
+			if err != nil {
+				f.Tr_WriteResponse(w, err.Error(), nil)
+				return
+			}
+			defer blocklistResponse.Body.Close()
+			blocklistBody, _ := io.ReadAll(blocklistResponse.Body)
+			f.Blocklist = string(blocklistBody)
+			f.Tr_WriteResponse(w, "success", map[string]int { "blocklist-size": strings.Count(f.Blocklist, "\n") })
+		case "torrent-start", "torrent-stop":
+			f.Tr_WriteResponse(w, "success", struct {} {})
+		default:
+			f.Tr_WriteResponse(w, "method name not recognized", nil)
+	}
+}
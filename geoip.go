@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"net"
+	"strconv"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type GeoIPInfoStruct struct {
+	ASN     uint
+	ASOrg   string
+	Country string
+}
+type GeoIPDatabaseStruct struct {
+	Path    string
+	LastMod int64
+	Reader  *maxminddb.Reader
+}
+
+// 兼容 MaxMind GeoLite2 及 DB-IP Lite 的 ASN/Country 数据库, 二者字段名称一致.
+type geoIPRecordStruct struct {
+	ASN     uint   `maxminddb:"autonomous_system_number"`
+	ASOrg   string `maxminddb:"autonomous_system_organization"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+var geoIPASNDatabase = GeoIPDatabaseStruct {}
+var geoIPCountryDatabase = GeoIPDatabaseStruct {}
+
+func LoadGeoIPDatabase(database *GeoIPDatabaseStruct, databasePath string) bool {
+	if databasePath == "" {
+		if database.Reader != nil {
+			database.Reader.Close()
+			*database = GeoIPDatabaseStruct {}
+		}
+		return false
+	}
+
+	databaseStat, err := os.Stat(databasePath)
+	if err != nil {
+		Log("Debug-LoadGeoIPDatabase", GetLangText("Error-LoadGeoIPDatabaseMeta"), false, databasePath, err.Error())
+		return false
+	}
+
+	databaseLastMod := databaseStat.ModTime().Unix()
+	if database.Path == databasePath && databaseLastMod <= database.LastMod {
+		return true
+	}
+
+	reader, err := maxminddb.Open(databasePath)
+	if err != nil {
+		Log("LoadGeoIPDatabase", GetLangText("Error-LoadGeoIPDatabase"), true, databasePath, err.Error())
+		return false
+	}
+
+	if database.Reader != nil {
+		database.Reader.Close()
+	}
+
+	*database = GeoIPDatabaseStruct { Path: databasePath, LastMod: databaseLastMod, Reader: reader }
+
+	Log("LoadGeoIPDatabase", GetLangText("Success-LoadGeoIPDatabase"), true, databasePath, reader.Metadata.DatabaseType)
+
+	return true
+}
+func LoadAllGeoIPDatabase() {
+	LoadGeoIPDatabase(&geoIPASNDatabase, config.GeoIPASNDatabase)
+	LoadGeoIPDatabase(&geoIPCountryDatabase, config.GeoIPCountryDatabase)
+}
+func IsGeoIPEnabled() bool {
+	return (geoIPASNDatabase.Reader != nil || geoIPCountryDatabase.Reader != nil)
+}
+func LookupGeoIPDatabase(database *GeoIPDatabaseStruct, ip net.IP, geoIPRecord *geoIPRecordStruct) {
+	if database.Reader == nil {
+		return
+	}
+
+	if err := database.Reader.Lookup(ip, geoIPRecord); err != nil {
+		Log("Debug-LookupGeoIPDatabase", "%s: %s", false, ip.String(), err.Error())
+	}
+}
+func GetGeoIPInfo(peerIP string) *GeoIPInfoStruct {
+	if !IsGeoIPEnabled() {
+		return nil
+	}
+
+	// 按 IP 段记录时, 使用 IP 段的起始地址查询.
+	ip := net.ParseIP(peerIP)
+	if ip == nil {
+		_, cidr, err := net.ParseCIDR(peerIP)
+		if err != nil {
+			return nil
+		}
+		ip = cidr.IP
+	}
+
+	// 同一数据库可能同时包含 ASN 及国家信息, 因此两个数据库均解码至同一结构.
+	geoIPRecord := geoIPRecordStruct {}
+	LookupGeoIPDatabase(&geoIPASNDatabase, ip, &geoIPRecord)
+	LookupGeoIPDatabase(&geoIPCountryDatabase, ip, &geoIPRecord)
+
+	return &GeoIPInfoStruct { ASN: geoIPRecord.ASN, ASOrg: geoIPRecord.ASOrg, Country: geoIPRecord.Country.ISOCode }
+}
+func FormatGeoIPInfo(geoIPInfo *GeoIPInfoStruct) string {
+	if geoIPInfo == nil {
+		return "Unknown"
+	}
+
+	asnStr := "Unknown"
+	if geoIPInfo.ASN != 0 {
+		asnStr = "AS" + strconv.FormatUint(uint64(geoIPInfo.ASN), 10)
+		if geoIPInfo.ASOrg != "" {
+			asnStr += " " + geoIPInfo.ASOrg
+		}
+	}
+
+	country := geoIPInfo.Country
+	if country == "" {
+		country = "Unknown"
+	}
+
+	return asnStr + ", " + country
+}
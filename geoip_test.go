@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// 测试数据库由 testdata/genmmdb.go 生成:
+// 203.0.113.0/24: AS64500 "Example Offline Download", CN.
+// 198.51.100.0/24: AS64501 "Example ISP", 其中仅 198.51.100.0/25 为 JP.
+func LoadTestGeoIPDatabase(t *testing.T) {
+	if !LoadGeoIPDatabase(&geoIPASNDatabase, "testdata/GeoIP-ASN-Test.mmdb") || !LoadGeoIPDatabase(&geoIPCountryDatabase, "testdata/GeoIP-Country-Test.mmdb") {
+		t.Fatal("LoadGeoIPDatabase failed")
+	}
+	t.Cleanup(func() {
+		LoadGeoIPDatabase(&geoIPASNDatabase, "")
+		LoadGeoIPDatabase(&geoIPCountryDatabase, "")
+	})
+}
+func TestGeoIPDatabaseVerify(t *testing.T) {
+	LoadTestGeoIPDatabase(t)
+
+	for _, database := range []*GeoIPDatabaseStruct { &geoIPASNDatabase, &geoIPCountryDatabase } {
+		if err := database.Reader.Verify(); err != nil {
+			t.Errorf("%s: %v", database.Path, err)
+		}
+	}
+}
+func TestGetGeoIPInfo(t *testing.T) {
+	if GetGeoIPInfo("203.0.113.7") != nil {
+		t.Fatal("GetGeoIPInfo without database: expected nil")
+	}
+
+	LoadTestGeoIPDatabase(t)
+
+	testCases := []struct {
+		ip     string
+		geoIP  GeoIPInfoStruct
+		format string
+	} {
+		{ "203.0.113.7", GeoIPInfoStruct { ASN: 64500, ASOrg: "Example Offline Download", Country: "CN" }, "AS64500 Example Offline Download, CN" },
+		{ "203.0.113.0/24", GeoIPInfoStruct { ASN: 64500, ASOrg: "Example Offline Download", Country: "CN" }, "AS64500 Example Offline Download, CN" },
+		{ "198.51.100.8", GeoIPInfoStruct { ASN: 64501, ASOrg: "Example ISP", Country: "JP" }, "AS64501 Example ISP, JP" },
+		{ "198.51.100.200", GeoIPInfoStruct { ASN: 64501, ASOrg: "Example ISP" }, "AS64501 Example ISP, Unknown" },
+		{ "192.0.2.1", GeoIPInfoStruct {}, "Unknown, Unknown" },
+		{ "2001:db8::1", GeoIPInfoStruct {}, "Unknown, Unknown" },
+	}
+
+	for _, testCase := range testCases {
+		geoIPInfo := GetGeoIPInfo(testCase.ip)
+		if geoIPInfo == nil {
+			t.Errorf("GetGeoIPInfo(%q) = nil", testCase.ip)
+			continue
+		}
+		if *geoIPInfo != testCase.geoIP {
+			t.Errorf("GetGeoIPInfo(%q) = %+v, want %+v", testCase.ip, *geoIPInfo, testCase.geoIP)
+		}
+		if format := FormatGeoIPInfo(geoIPInfo); format != testCase.format {
+			t.Errorf("FormatGeoIPInfo(%q) = %q, want %q", testCase.ip, format, testCase.format)
+		}
+	}
+
+	if GetGeoIPInfo("not-an-ip") != nil {
+		t.Error("GetGeoIPInfo(\"not-an-ip\"): expected nil")
+	}
+}
+func TestGeoIPRuleFields(t *testing.T) {
+	LoadTestGeoIPDatabase(t)
+
+	testCases := []struct {
+		ip        string
+		condition string
+		result    bool
+	} {
+		{ "203.0.113.7", `asn == 64500`, true },
+		{ "203.0.113.7", `asn in "64500-64510"`, true },
+		{ "203.0.113.7", `asorg ~ "offline download"`, true },
+		{ "203.0.113.7", `country == "cn"`, true },
+		{ "203.0.113.7", `asn == 64500 && country != "CN"`, false },
+		{ "198.51.100.200", `asn == 64501 && country == ""`, true },
+		{ "192.0.2.1", `asn == 0 && asorg == "" && country == ""`, true },
+	}
+
+	for _, testCase := range testCases {
+		node, err := ParseRuleCondition(testCase.condition)
+		if err != nil {
+			t.Errorf("ParseRuleCondition(%q): %v", testCase.condition, err)
+			continue
+		}
+		ruleContext := NewPeerRuleContext(testCase.ip, 6881, "-XL0019-", "Xunlei 0019", 10240, 10240, 0.5, 0, 0, false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false)
+		if result := RuleValueToBool(node.Eval(ruleContext)); result != testCase.result {
+			t.Errorf("%s: %q = %t, want %t", testCase.ip, testCase.condition, result, testCase.result)
+		}
+	}
+}
+func TestCheckPeerAllowRule(t *testing.T) {
+	LoadTestGeoIPDatabase(t)
+
+	// allow 规则放行的 Peer 应跳过内置检查 (此处为客户端黑名单).
+	allowRule, err := CompileRule(RuleConfigStruct { Name: "AllowISP", Condition: `asn == 64501 && country == "JP"`, Action: "allow", Priority: 1 })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+
+	lastRulesCompiled := rulesCompiled
+	lastBlockListCompiled := blockListCompiled
+	rulesCompiled = []*RuleStruct { allowRule }
+	blockListCompiled = []*regexp.Regexp { regexp.MustCompile("(?i)Xunlei") }
+	dryRunMode = true
+	dryRunReasons = []string {}
+	defer func() {
+		rulesCompiled = lastRulesCompiled
+		blockListCompiled = lastBlockListCompiled
+		dryRunMode = false
+		dryRunReasons = nil
+	}()
+
+	testCases := []struct {
+		ip     string
+		status int
+	} {
+		{ "198.51.100.8", -3 },
+		{ "198.51.100.200", 1 },
+		{ "203.0.113.7", 1 },
+	}
+
+	for _, testCase := range testCases {
+		peerStatus, _, _ := CheckPeer(testCase.ip, 6881, "-XL0019-", "Xunlei 0019", 10240, 10240, 0.5, 0, 0, false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false, &config)
+		if peerStatus != testCase.status {
+			t.Errorf("CheckPeer(%q) = %d, want %d", testCase.ip, peerStatus, testCase.status)
+		}
+	}
+}
@@ -3,10 +3,16 @@ module github.com/Simple-Tracker/qBittorrent-ClientBlocker
 go 1.20
 
 require (
-	github.com/Xuanwo/go-locale v1.1.0 // indirect
-	github.com/lxn/win v0.0.0-20210218163916-a377121e959e // indirect
-	github.com/tidwall/jsonc v0.3.2 // indirect
-	golang.design/x/hotkey v0.4.1 // indirect
+	github.com/BurntSushi/toml v1.3.2
+	github.com/Xuanwo/go-locale v1.1.0
+	github.com/lxn/win v0.0.0-20210218163916-a377121e959e
+	github.com/oschwald/maxminddb-golang v1.12.0
+	github.com/tidwall/jsonc v0.3.2
+	golang.design/x/hotkey v0.4.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	golang.org/x/sys v0.16.0 // indirect
 	golang.org/x/text v0.3.7 // indirect
 )
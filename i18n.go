@@ -29,11 +29,35 @@ var defaultLangContent = map[string]string {
 	"CheckUpdate-Ignore_UnknownVersion": "跳过自动检查更新: 未知版本",
 	"CheckUpdate-Ignore_NightlyVersion": "跳过自动检查更新: 夜间构建版本",
 	"CheckUpdate-Ignore_BadVersion": "跳过自动检查更新: 错误版本 %s",
+	"SetURL_ReverseProxy": "客户端已启用反向代理支持, 若需通过反向代理访问, 请手动设置 clientURL (当前: %s)",
+	"SetURL_NeedPassword": "客户端启用了本机认证, 但未设置 clientPassword",
+	"CheckConfig": "检查配置文件后退出",
+	"Warning-ConfigIssue": "配置警告: %s: %s: %s",
+	"ApplyConfig_Changed": "配置项已变更: %s",
+	"ReconfigureRuntime_Interval": "检查间隔已变更为 %d 秒",
+	"ReconfigureRuntime_Listen": "监听地址已变更为 %s, 正在重启内置服务器",
+	"LoadInitConfig_Relogin": "客户端认证信息已变更, 正在重新登录",
+	"Warning-ConfigPermission": "配置文件 %s 可被所有用户读取 (%s), 其中的客户端密码可能泄露. 建议修改权限为 600, 或改用 clientPasswordFile/环境变量引用",
+	"Warning-EnvConfigUnknown": "环境变量 %s 不对应任何配置项",
+	"Warning-ControlUnban_KeptCIDR": "%s 仍被 IP 段 %s 封禁, 如需解除请指定该 IP 段",
+	"TestPeer": "离线测试 Peer 后退出 (格式: ip=1.2.3.4&port=6881&client=...)",
+	"TestFile": "离线测试 Peer 文件 (JSON/CSV) 后退出",
+	"Capture": "记录每个周期从客户端获取的 Torrent 及 Peer 至指定文件 (gzip 压缩的 JSONL)",
+	"Replay": "以配置文件回放记录文件, 输出封禁决策后退出",
 	"Debug-LoadConfig_HotReload": "发现配置文件更改, 正在进行热重载",
 	"Debug-ShowOrHiddenWindow_HideWindow": "窗口隐藏",
 	"Debug-ShowOrHiddenWindow_ShowWindow": "窗口显示",
 	"Debug-RestartTorrentByMap_Wait": "重新开始 Torrent 前的等待间隔: %d 秒",
+	"Debug-SetURL_HostHeaderValidation": "客户端启用了 Host 头验证, 服务器域名: %s",
+	"Debug-SetURL_LocalHostAuthDisabled": "客户端已启用跳过本机客户端认证",
+	"Debug-Request_Retry": "已重新认证, 正在重试请求: %s",
+	"Debug-Request_CircuitOpen": "客户端请求已暂停, 跳过请求: %s",
 	"Abandon-SetURL": "放弃读取客户端配置文件 (WebUIEnabled: %t, Address: %s)",
+	"Abandon-SetURL_File": "放弃客户端配置文件 %s: %s",
+	"Abandon-SetURL_NotExist": "客户端配置文件不存在: %s",
+	"Abandon-SetURL_WebUIDisabled": "Web UI 未启用",
+	"Abandon-SetURL_BadPort": "Web UI 端口无效",
+	"Abandon-UnthrottleTorrent": "Torrent %s 可能已被删除, 不再恢复其原有限速",
 	"Error": "发生错误",
 	"Error-LoadLang": "加载语言文件时发生了错误 %s",
 	"Error-ReadLang": "读取语言文件时发生了错误 %s|%s",
@@ -71,11 +95,36 @@ var defaultLangContent = map[string]string {
 	"Error-Debug-EmptyLine": ":%d 为空",
 	"Error-Debug-GetConfigPath_GetUserHomeDir": "获取 User Home 目录时发生了错误: %s",
 	"Error-Debug-GetConfigPath_GetUserConfigDir": "获取 User Config 目录时发生了错误: %s",
+	"Error-Request_CircuitOpen": "客户端请求连续失败 %d 次, 暂停请求 %d 秒",
+	"Error-Request_Timeout": "请求超时: %s",
+	"Error-Request_CSRF": "请求时发生了错误: CSRF Token 无效",
+	"Error-Request_CircuitOpenSkip": "客户端请求已暂停",
+	"Error-Request_TooLarge": "响应大小超过限制: %d 字节",
+	"Error-CompileRule": "规则 %s 有错误: %s",
+	"Error-LoadGeoIPDatabaseMeta": "读取 GeoIP 数据库 %s 元数据时发生了错误: %s",
+	"Error-LoadGeoIPDatabase": "加载 GeoIP 数据库 %s 时发生了错误: %s",
+	"Error-CompileTorrentOverride": "Torrent 覆盖配置 %s 有错误: %s",
+	"Error-CompilePortBlockList": "端口 %s 有错误: %s",
+	"Error-CIDRPromotionLadder": "IP 段晋升前缀 %s 有错误",
+	"Error-ConfigIssue": "配置错误: %s: %s: %s",
+	"Error-ControlCommandUsage": "未知或参数不足的子命令: %s. 可用子命令: status, list-bans, ban <ip|cidr> [duration], unban <ip|cidr>, reload, test-peer <ip> [port] [key=value ...]",
+	"Error-RunControlCommand": "无法连接运行中的实例: %s",
+	"Error-LoadTestPeer": "读取测试 Peer %s 时发生了错误: %s",
+	"Error-SaveCapture": "写入记录文件时发生了错误: %s",
+	"Error-LoadCapture": "读取记录文件 %s 时发生了错误: %s",
+	"Error-LoadThrottleState": "读取限速状态文件 %s 时发生了错误: %s",
+	"Error-SaveThrottleState": "写入限速状态文件 %s 时发生了错误: %s",
+	"Error-LoadControlToken": "读取或生成控制接口 Token 文件 %s 时发生了错误: %s",
 	"Failed-LoadInitConfig": "读取配置文件失败或不完整",
 	"Failed-ChangeWorkingDir": "切换工作目录失败: %s",
 	"Failed-Login_BadUsernameOrPassword": "登录失败: 账号或密码错误",
 	"Failed-Login_Other": "登录失败: %s",
 	"Failed-ExecCommand": "执行命令失败",
+	"Failed-ThrottleTorrent": "对 Torrent %s 限速失败 (规则: %s)",
+	"Failed-UnthrottleTorrent": "恢复 Torrent %s 原有限速失败, 将在下个周期重试",
+	"Failed-CheckConfig": "配置检查失败, %d 个错误, %d 个警告",
+	"Failed-ApplyConfig": "新配置存在 %d 个错误, 继续使用当前配置",
+	"Failed-RestoreThrottleTorrent": "恢复 Torrent %s 原有限速失败, 将在下次启动时重试",
 	"Success-RegHotkey": "已注册并开始监听窗口热键: CTRL+ALT+B",
 	"Success-ChangeWorkingDir": "切换工作目录: %s",
 	"Success-LoadConfig": "加载配置文件成功",
@@ -87,6 +136,17 @@ var defaultLangContent = map[string]string {
 	"Success-Login": "登录成功",
 	"Success-ClearBlockPeer": "已清理过期客户端: %d 个",
 	"Success-ExecCommand": "执行命令成功, 输出: %s",
+	"Success-Request_CircuitClose": "客户端请求已恢复",
+	"Success-LoadGeoIPDatabase": "加载 GeoIP 数据库 %s 成功 (%s)",
+	"Success-ThrottleTorrent": "已对 Torrent %s 限速 %d KB/s (规则: %s)",
+	"Success-UnthrottleTorrent": "可疑 Peer 均已离开, 已恢复 Torrent %s 原有限速",
+	"Success-CheckConfig": "配置检查通过, %d 个警告",
+	"Success-ApplyConfig": "应用配置成功, %d 个配置项已变更",
+	"Success-ControlBan": "已手动封禁 %s, 封禁时长: %d 秒 (0 表示默认)",
+	"Success-ControlUnban": "已手动解除封禁 %s, 涉及 IP: %d 个, IP 段: %d 个",
+	"Success-RunReplay": "回放完成, 共 %d 个周期, 回放结束时封禁 %d 个 IP",
+	"Success-RestoreThrottleTorrent": "已恢复 Torrent %s 原有限速",
+	"Success-LoadThrottleState": "已读取限速状态文件, 将恢复 %d 个 Torrent 的原有限速",
 }
 
 func LoadLang(langCode string) bool {
@@ -1,6 +1,9 @@
 package main
 
-import "net"
+import (
+	"net"
+	"strings"
+)
 
 type IPInfoStruct struct {
 	Net  *net.IPNet
@@ -10,11 +13,19 @@ type IPInfoStruct struct {
 type BlockCIDRInfoStruct struct {
 	Timestamp int64
 	Net       *net.IPNet
+	BanTime   int64
+}
+
+type CorrelationInfoStruct struct {
+	Net        *net.IPNet
+	IPTorrents map[string]map[string]float64
 }
 
 var ipMap = make(map[string]IPInfoStruct)
 var lastIPMap = make(map[string]IPInfoStruct)
 var lastIPCleanTimestamp int64 = 0
+var correlationMap = make(map[string]CorrelationInfoStruct)
+var cidrPromotionMap = make(map[string]int64)
 
 func AddIPInfo(cidr *net.IPNet, peerIP string, peerPort int, torrentInfoHash string, peerUploaded int64) {
 	if !(config.MaxIPPortCount > 0 || (config.IPUploadedCheck && config.IPUpCheckIncrementMB > 0)) {
@@ -71,15 +82,30 @@ func IsMatchCIDR(ip string) (bool, *net.IPNet) {
 		if _, exist := blockCIDRMap[peerNetStr]; exist {
 			return true, peerNet
 		}
-		return false, peerNet
 	}
 
-	return false, nil
+	// 规则可能以不同于配置的前缀封禁 IP 段, 因此逐一检查.
+	if parsedIP := net.ParseIP(ip); parsedIP != nil {
+		for _, blockCIDRInfo := range blockCIDRMap {
+			if blockCIDRInfo.BanTime > 0 && blockCIDRInfo.Net != nil && blockCIDRInfo.Net.Contains(parsedIP) {
+				return true, peerNet
+			}
+		}
+	}
+
+	return false, peerNet
 }
 func CheckAllIP(ipMap map[string]IPInfoStruct, lastIPMap map[string]IPInfoStruct) int {
-	if (config.MaxIPPortCount > 0 || (config.IPUploadedCheck && config.IPUpCheckIncrementMB > 0)) && len(lastIPMap) > 0 && currentTimestamp > (lastIPCleanTimestamp + int64(config.IPUpCheckInterval)) {
+	if (config.MaxIPPortCount > 0 || (config.IPUploadedCheck && config.IPUpCheckIncrementMB > 0)) && currentTimestamp > (lastIPCleanTimestamp + int64(config.IPUpCheckInterval)) {
 		ipBlockCount := 0
 
+		// lastIPMap 仅在比较后更新, 因此首个周期须先记录, 否则依赖上个周期的检查永远不会执行.
+		if len(lastIPMap) <= 0 {
+			lastIPCleanTimestamp = currentTimestamp
+			DeepCopyIPMap(ipMap, lastIPMap)
+			return 0
+		}
+
 		ipMapLoop:
 		for ip, ipInfo := range ipMap {
 			if IsBlockedPeer(ip, -1, true) || len(ipInfo.Port) <= 0 {
@@ -124,3 +150,179 @@ func CheckAllIP(ipMap map[string]IPInfoStruct, lastIPMap map[string]IPInfoStruct
 
 	return 0
 }
+func GetCIDRPromotionLadder(peerIP string) []string {
+	if IsIPv6(peerIP) {
+		return config.CIDRPromotion6Ladder
+	}
+
+	return config.CIDRPromotionLadder
+}
+func PromoteBlockCIDR(peerIP string) {
+	if config.CIDRPromotionCount <= 0 {
+		return
+	}
+
+	ip := net.ParseIP(peerIP)
+	if ip == nil {
+		return
+	}
+
+	// 仅统计窗口期内被封禁的不同 IP.
+	expireTimestamp := (currentTimestamp - int64(config.CIDRPromotionWindow))
+	for promotionIP, promotionTimestamp := range cidrPromotionMap {
+		if promotionTimestamp < expireTimestamp {
+			delete(cidrPromotionMap, promotionIP)
+		}
+	}
+	cidrPromotionMap[peerIP] = currentTimestamp
+
+	// 在满足数量的 IP 段中选择最宽者.
+	var promotionNet *net.IPNet
+	promotionIPCount := 0
+	promotionOnes := 129
+	for _, cidr := range GetCIDRPromotionLadder(peerIP) {
+		peerNet := ParseIPCIDR(peerIP + cidr)
+		if peerNet == nil {
+			continue
+		}
+
+		ones, _ := peerNet.Mask.Size()
+		if ones >= promotionOnes {
+			continue
+		}
+
+		ipCount := 0
+		for promotionIP := range cidrPromotionMap {
+			if parsedIP := net.ParseIP(promotionIP); parsedIP != nil && peerNet.Contains(parsedIP) {
+				ipCount++
+			}
+		}
+
+		if ipCount >= int(config.CIDRPromotionCount) {
+			promotionNet = peerNet
+			promotionIPCount = ipCount
+			promotionOnes = ones
+		}
+	}
+
+	if promotionNet == nil {
+		return
+	}
+
+	promotionNetStr := promotionNet.String()
+	if _, exist := blockCIDRMap[promotionNetStr]; exist {
+		return
+	}
+
+	Log("PromoteBlockCIDR (Bad-CIDR_Promotion)", "%s (IP: %s, IPCount: %d, Window: %d)", true, promotionNetStr, peerIP, promotionIPCount, config.CIDRPromotionWindow)
+	blockCIDRMap[promotionNetStr] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: promotionNet, BanTime: int64(config.BanTime) }
+}
+func AddCorrelationInfo(peerIP string, torrentInfoHash string, peerProgress float64) {
+	if !config.BanByCorrelation {
+		return
+	}
+
+	cidr := config.CorrelationIPCIDR
+	if IsIPv6(peerIP) {
+		cidr = config.CorrelationIP6CIDR
+	}
+
+	peerNet := ParseIPCIDR(peerIP + cidr)
+	if peerNet == nil {
+		return
+	}
+
+	peerNetStr := peerNet.String()
+	correlationInfo, exist := correlationMap[peerNetStr]
+	if !exist {
+		correlationInfo = CorrelationInfoStruct { Net: peerNet, IPTorrents: make(map[string]map[string]float64) }
+		correlationMap[peerNetStr] = correlationInfo
+	}
+
+	if _, exist := correlationInfo.IPTorrents[peerIP]; !exist {
+		correlationInfo.IPTorrents[peerIP] = make(map[string]float64)
+	}
+	correlationInfo.IPTorrents[peerIP][torrentInfoHash] = peerProgress
+}
+func IsCorrelationManyTorrents(correlationInfo CorrelationInfoStruct) int {
+	// 整个 IP 段同时连接大量 Torrent, 且在所有 Torrent 上的进度均接近 0.
+	torrentMap := make(map[string]bool)
+	for _, ipTorrents := range correlationInfo.IPTorrents {
+		for torrentInfoHash, peerProgress := range ipTorrents {
+			if peerProgress > (config.CorrelationMaxProgress / 100) {
+				return 0
+			}
+			torrentMap[torrentInfoHash] = true
+		}
+	}
+
+	if len(torrentMap) >= int(config.CorrelationMinTorrents) {
+		return len(torrentMap)
+	}
+
+	return 0
+}
+func IsCorrelationManyIPs(correlationInfo CorrelationInfoStruct) (string, int) {
+	// 同一 Torrent 被 IP 段内的大量不同地址同时下载.
+	torrentIPCountMap := make(map[string]int)
+	for _, ipTorrents := range correlationInfo.IPTorrents {
+		for torrentInfoHash := range ipTorrents {
+			torrentIPCountMap[torrentInfoHash]++
+		}
+	}
+
+	for torrentInfoHash, ipCount := range torrentIPCountMap {
+		if ipCount >= int(config.CorrelationMinIPPerTorrent) {
+			return torrentInfoHash, ipCount
+		}
+	}
+
+	return "", 0
+}
+func BlockCorrelation(correlationInfo CorrelationInfoStruct, torrentInfoHash string) int {
+	blockCount := 0
+
+	blockCIDRMap[correlationInfo.Net.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: correlationInfo.Net, BanTime: int64(config.BanTime) }
+	for ip := range correlationInfo.IPTorrents {
+		if IsBlockedPeer(ip, -1, true) {
+			continue
+		}
+		blockCount++
+		AddBlockPeer(ip, -1, torrentInfoHash)
+	}
+
+	return blockCount
+}
+func CheckAllCorrelation() int {
+	if !config.BanByCorrelation {
+		return 0
+	}
+
+	ipBlockCount := 0
+
+	for cidrStr, correlationInfo := range correlationMap {
+		if torrentCount := IsCorrelationManyTorrents(correlationInfo); torrentCount > 0 {
+			Log("CheckAllCorrelation_AddBlockPeer (Bad-Correlation_ManyTorrents)", "%s (IPCount: %d, TorrentCount: %d, IP: %s)", true, cidrStr, len(correlationInfo.IPTorrents), torrentCount, GetCorrelationIPStr(correlationInfo))
+			ipBlockCount += BlockCorrelation(correlationInfo, "")
+			continue
+		}
+
+		if torrentInfoHash, ipCount := IsCorrelationManyIPs(correlationInfo); ipCount > 0 {
+			Log("CheckAllCorrelation_AddBlockPeer (Bad-Correlation_ManyIPs)", "%s (TorrentInfoHash: %s, IPCount: %d, IP: %s)", true, cidrStr, torrentInfoHash, ipCount, GetCorrelationIPStr(correlationInfo))
+			ipBlockCount += BlockCorrelation(correlationInfo, torrentInfoHash)
+		}
+	}
+
+	// 仅统计同一周期内同时连接的情况.
+	correlationMap = make(map[string]CorrelationInfoStruct)
+
+	return ipBlockCount
+}
+func GetCorrelationIPStr(correlationInfo CorrelationInfoStruct) string {
+	ipArr := []string {}
+	for ip := range correlationInfo.IPTorrents {
+		ipArr = append(ipArr, ip)
+	}
+
+	return strings.Join(ipArr, ",")
+}
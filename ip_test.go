@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPromoteBlockCIDR(t *testing.T) {
+	lastConfig := config
+	lastBlockPeerMap := blockPeerMap
+	lastBlockCIDRMap := blockCIDRMap
+	lastCIDRPromotionMap := cidrPromotionMap
+	lastTimestamp := currentTimestamp
+	defer func() {
+		config = lastConfig
+		blockPeerMap = lastBlockPeerMap
+		blockCIDRMap = lastBlockCIDRMap
+		cidrPromotionMap = lastCIDRPromotionMap
+		currentTimestamp = lastTimestamp
+	}()
+
+	// 启用 IP 段升级时, 不应按 banIPCIDR 固定扩大封禁范围.
+	config.BanIPCIDR = "/16"
+	config.CIDRPromotionCount = 3
+	config.CIDRPromotionWindow = 600
+	config.CIDRPromotionLadder = []string { "/24" }
+	config.CIDRPromotion6Ladder = []string { "/64", "/48" }
+
+	testCases := []struct {
+		name    string
+		ips     []string
+		offsets []int64
+		cidr    string
+		promote bool
+	} {
+		{ "below threshold", []string { "203.0.113.1", "203.0.113.2" }, []int64 { 0, 1 }, "203.0.113.0/24", false },
+		{ "at threshold", []string { "203.0.113.1", "203.0.113.2", "203.0.113.3" }, []int64 { 0, 1, 2 }, "203.0.113.0/24", true },
+		{ "window boundary", []string { "198.51.100.1", "198.51.100.2", "198.51.100.3" }, []int64 { 0, 300, 600 }, "198.51.100.0/24", true },
+		{ "window expired", []string { "198.51.100.1", "198.51.100.2", "198.51.100.3" }, []int64 { 0, 300, 601 }, "198.51.100.0/24", false },
+		{ "other prefix", []string { "192.0.2.1", "192.0.2.2", "203.0.113.1" }, []int64 { 0, 1, 2 }, "192.0.2.0/24", false },
+		{ "widest prefix", []string { "2001:db8::1", "2001:db8::2", "2001:db8::3" }, []int64 { 0, 1, 2 }, "2001:db8::/48", true },
+	}
+
+	for _, testCase := range testCases {
+		blockPeerMap = make(map[string]BlockPeerInfoStruct)
+		blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
+		cidrPromotionMap = make(map[string]int64)
+
+		for k, ip := range testCase.ips {
+			currentTimestamp = 1700000000 + testCase.offsets[k]
+			AddBlockPeer(ip, 6881, "")
+		}
+
+		if _, promote := blockCIDRMap[testCase.cidr]; promote != testCase.promote {
+			t.Errorf("%s: %s promoted = %t, want %t", testCase.name, testCase.cidr, promote, testCase.promote)
+		}
+		if testCase.promote && len(blockCIDRMap) != 1 {
+			t.Errorf("%s: blockCIDRMap = %v, want only %s", testCase.name, blockCIDRMap, testCase.cidr)
+		}
+		if !testCase.promote && len(blockCIDRMap) != 0 {
+			t.Errorf("%s: blockCIDRMap = %v, want empty", testCase.name, blockCIDRMap)
+		}
+	}
+
+	// 禁用 IP 段升级时, 仍按 banIPCIDR 扩大封禁范围.
+	config.CIDRPromotionCount = 0
+	blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
+	AddBlockPeer("203.0.113.1", 6881, "")
+	if _, exist := blockCIDRMap["203.0.0.0/16"]; !exist || len(blockCIDRMap) != 1 {
+		t.Errorf("banIPCIDR: blockCIDRMap = %v, want only 203.0.0.0/16", blockCIDRMap)
+	}
+}
+func TestCheckAllIP(t *testing.T) {
+	lastConfig := config
+	lastBlockPeerMap := blockPeerMap
+	lastBlockCIDRMap := blockCIDRMap
+	lastCleanTimestamp := lastIPCleanTimestamp
+	lastTimestamp := currentTimestamp
+	defer func() {
+		config = lastConfig
+		blockPeerMap = lastBlockPeerMap
+		blockCIDRMap = lastBlockCIDRMap
+		lastIPCleanTimestamp = lastCleanTimestamp
+		currentTimestamp = lastTimestamp
+	}()
+
+	config.MaxIPPortCount = 2
+	config.IPUploadedCheck = true
+	config.IPUpCheckIncrementMB = 10
+	config.IPUpCheckInterval = 300
+	config.CIDRPromotionCount = 0
+	blockPeerMap = make(map[string]BlockPeerInfoStruct)
+	blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
+	lastIPCleanTimestamp = 0
+	currentTimestamp = 1700000000
+
+	testIPMap := map[string]IPInfoStruct {
+		"203.0.113.7": IPInfoStruct { Port: map[int]bool { 6881: true, 6882: true, 6883: true }, TorrentUploaded: map[string]int64 { "0123456789abcdef0123456789abcdef01234567": 0 } },
+		"198.51.100.8": IPInfoStruct { Port: map[int]bool { 51413: true }, TorrentUploaded: map[string]int64 { "0123456789abcdef0123456789abcdef01234567": 0 } },
+	}
+	testLastIPMap := make(map[string]IPInfoStruct)
+
+	// 首个周期仅记录 lastIPMap, 不进行检查.
+	if ipBlockCount := CheckAllIP(testIPMap, testLastIPMap); ipBlockCount != 0 || len(blockPeerMap) != 0 {
+		t.Fatalf("first cycle: ipBlockCount = %d, blockPeerMap = %v, want none", ipBlockCount, blockPeerMap)
+	}
+	if len(testLastIPMap) != 2 {
+		t.Fatalf("first cycle: lastIPMap = %v, want seeded", testLastIPMap)
+	}
+
+	// 未超过 IPUpCheckInterval 时不检查.
+	currentTimestamp += 300
+	if ipBlockCount := CheckAllIP(testIPMap, testLastIPMap); ipBlockCount != 0 {
+		t.Fatalf("within interval: ipBlockCount = %d, want 0", ipBlockCount)
+	}
+
+	// 下个周期: 端口过多及上传增量过高均应封禁.
+	currentTimestamp += 1
+	testIPMap["198.51.100.8"].TorrentUploaded["0123456789abcdef0123456789abcdef01234567"] = (20 * 1024 * 1024)
+	if ipBlockCount := CheckAllIP(testIPMap, testLastIPMap); ipBlockCount != 2 {
+		t.Fatalf("second cycle: ipBlockCount = %d, want 2", ipBlockCount)
+	}
+	for _, ip := range []string { "203.0.113.7", "198.51.100.8" } {
+		if !IsBlockedPeer(ip, -1, true) {
+			t.Errorf("%s not blocked", ip)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"os"
 	"fmt"
 	"strings"
@@ -9,8 +10,15 @@ import (
 var todayStr = ""
 var lastLogPath = ""
 var logFile *os.File
+var logOutput io.Writer = os.Stdout
 
 func Log(module string, str string, logToFile bool, args ...interface {}) {
+	if dryRunMode {
+		if RecordDryRunReason(module, fmt.Sprintf(str, args...)) {
+			return
+		}
+		logToFile = false
+	}
 	if strings.HasPrefix(module, "Debug") {
 		if !config.Debug {
 			return
@@ -24,7 +32,7 @@ func Log(module string, str string, logToFile bool, args ...interface {}) {
 			Log("Log", GetLangText("Error-Log_Write"), false, err.Error())
 		}
 	}
-	fmt.Print(logStr)
+	fmt.Fprint(logOutput, logStr)
 }
 func LoadLog() bool {
 	if !config.LogToFile || config.LogPath == "" {
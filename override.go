@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sort"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/json"
+)
+
+type TorrentOverrideConfigStruct struct {
+	Name     string
+	Category string
+	Tag      string
+	Tracker  string
+	InfoHash string
+	Skip     bool
+	Enforce  bool
+	Config   map[string]interface{}
+}
+type TorrentOverrideStruct struct {
+	TorrentOverrideConfigStruct
+	Config *ConfigStruct
+}
+
+var torrentOverridesCompiled []*TorrentOverrideStruct
+var torrentOverrideMap = make(map[string]*TorrentOverrideStruct)
+
+// 可按 Torrent 覆盖的配置项. 其余配置项 (如 blockList/ipBlockList/portBlockList/rules) 为全局编译, 覆盖无效.
+var torrentOverrideConfigKeys = []string { "banTime", "banIPCIDR", "banIP6CIDR", "ignoreEmptyPeer", "ignorePTTorrent", "ignoreByDownloaded", "ipUploadedCheck", "ipUpCheckPerTorrentRatio", "banByPeerIDMismatch", "banByProgressUploaded", "banByPUStartMB", "banByPUStartPrecent", "banByPUAntiErrorRatio", "banByRelativeProgressUploaded", "banByRelativePUStartMB", "banByRelativePUStartPrecent", "banByRelativePUAntiErrorRatio", "banByStagnantProgress", "stagnantProgressCycles", "stagnantProgressUploadedMB", "stagnantProgressGracePeriod" }
+
+func IsTorrentOverrideConfigKey(key string) bool {
+	// 与 encoding/json 一致, 键名大小写不敏感.
+	for _, torrentOverrideConfigKey := range torrentOverrideConfigKeys {
+		if strings.EqualFold(torrentOverrideConfigKey, key) {
+			return true
+		}
+	}
+
+	return false
+}
+func GetInvalidTorrentOverrideConfigKeys(torrentOverrideConfig TorrentOverrideConfigStruct) []string {
+	invalidKeys := []string {}
+	for key := range torrentOverrideConfig.Config {
+		if !IsTorrentOverrideConfigKey(key) {
+			invalidKeys = append(invalidKeys, key)
+		}
+	}
+	sort.Strings(invalidKeys)
+
+	return invalidKeys
+}
+
+func CompileTorrentOverride(torrentOverrideConfig TorrentOverrideConfigStruct) (*TorrentOverrideStruct, error) {
+	if torrentOverrideConfig.Category == "" && torrentOverrideConfig.Tag == "" && torrentOverrideConfig.Tracker == "" && torrentOverrideConfig.InfoHash == "" {
+		return nil, errors.New("no category, tag, tracker or infoHash")
+	}
+
+	if invalidKeys := GetInvalidTorrentOverrideConfigKeys(torrentOverrideConfig); len(invalidKeys) > 0 {
+		return nil, errors.New("config key " + strings.Join(invalidKeys, ", ") + " cannot be overridden per torrent")
+	}
+
+	torrentOverrideConfig.InfoHash = strings.ToLower(torrentOverrideConfig.InfoHash)
+	torrentOverrideConfig.Tracker = strings.ToLower(torrentOverrideConfig.Tracker)
+
+	torrentOverride := &TorrentOverrideStruct { TorrentOverrideConfigStruct: torrentOverrideConfig }
+	if len(torrentOverrideConfig.Config) <= 0 && !torrentOverrideConfig.Enforce {
+		return torrentOverride, nil
+	}
+
+	// 以当前全局配置的深拷贝为基础, 覆盖指定的配置项, 防止修改全局配置中的切片.
+	overrideConfigJSON, err := json.Marshal(torrentOverrideConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	overrideConfig := DeepCopyConfig(config)
+	if err := json.Unmarshal(overrideConfigJSON, &overrideConfig); err != nil {
+		return nil, err
+	}
+	overrideConfig.TorrentOverrides = nil
+
+	if torrentOverrideConfig.Enforce {
+		overrideConfig.IgnorePTTorrent = false
+	}
+
+	torrentOverride.Config = &overrideConfig
+
+	return torrentOverride, nil
+}
+func CompileTorrentOverrides() {
+	torrentOverridesCompiled = []*TorrentOverrideStruct {}
+	torrentOverrideMap = make(map[string]*TorrentOverrideStruct)
+
+	for k, torrentOverrideConfig := range config.TorrentOverrides {
+		if torrentOverrideConfig.Name == "" {
+			torrentOverrideConfig.Name = "#" + strconv.Itoa(k)
+		}
+
+		Log("Debug-LoadConfig_CompileTorrentOverride", "%s", false, torrentOverrideConfig.Name)
+
+		torrentOverride, err := CompileTorrentOverride(torrentOverrideConfig)
+		if err != nil {
+			Log("LoadConfig_CompileTorrentOverride", GetLangText("Error-CompileTorrentOverride"), true, torrentOverrideConfig.Name, err.Error())
+			continue
+		}
+
+		torrentOverridesCompiled = append(torrentOverridesCompiled, torrentOverride)
+	}
+}
+func IsTorrentOverrideMatch(torrentOverride *TorrentOverrideStruct, torrentInfoHash string, torrentCategory string, torrentTags []string, torrentTrackers []string) bool {
+	if torrentOverride.InfoHash != "" && torrentOverride.InfoHash != torrentInfoHash {
+		return false
+	}
+
+	if torrentOverride.Category != "" && torrentOverride.Category != torrentCategory {
+		return false
+	}
+
+	if torrentOverride.Tag != "" {
+		matchTag := false
+		for _, torrentTag := range torrentTags {
+			if torrentOverride.Tag == torrentTag {
+				matchTag = true
+				break
+			}
+		}
+		if !matchTag {
+			return false
+		}
+	}
+
+	if torrentOverride.Tracker != "" {
+		matchTracker := false
+		for _, torrentTracker := range torrentTrackers {
+			if IsTrackerHostInList(GetTrackerHost(torrentTracker), []string { torrentOverride.Tracker }) {
+				matchTracker = true
+				break
+			}
+		}
+		if !matchTracker {
+			return false
+		}
+	}
+
+	return true
+}
+func MatchTorrentOverride(torrentInfoHash string, torrentCategory string, torrentTags []string, torrentTrackers []string) *TorrentOverrideStruct {
+	// 所有已配置的条件均需匹配, 以配置顺序中首个匹配者为准.
+	for _, torrentOverride := range torrentOverridesCompiled {
+		if IsTorrentOverrideMatch(torrentOverride, torrentInfoHash, torrentCategory, torrentTags, torrentTrackers) {
+			torrentOverrideMap[torrentInfoHash] = torrentOverride
+			return torrentOverride
+		}
+	}
+
+	delete(torrentOverrideMap, torrentInfoHash)
+
+	return nil
+}
+func GetTorrentConfig(torrentOverride *TorrentOverrideStruct) *ConfigStruct {
+	// 返回 Torrent 使用的配置, 仅供读取. 全局配置不会被替换, 以免与其它 goroutine 产生竞争.
+	if torrentOverride == nil || torrentOverride.Config == nil {
+		return &config
+	}
+
+	return torrentOverride.Config
+}
+func IsTorrentOverrideMapEnabled() bool {
+	for _, torrentOverride := range torrentOverridesCompiled {
+		if torrentOverride.Config != nil && IsTorrentMapEnabled(torrentOverride.Config) {
+			return true
+		}
+	}
+
+	return false
+}
+func SplitTorrentTags(torrentTagsStr string) []string {
+	// qBittorrent 以 ", " 分隔标签.
+	torrentTags := []string {}
+	for _, torrentTag := range strings.Split(torrentTagsStr, ",") {
+		if torrentTag = StrTrim(torrentTag); torrentTag != "" {
+			torrentTags = append(torrentTags, torrentTag)
+		}
+	}
+
+	return torrentTags
+}
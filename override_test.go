@@ -0,0 +1,100 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTorrentOverrideBanTime(t *testing.T) {
+	// 覆盖配置不应修改全局配置, 且其 banTime 应记录于封禁信息中.
+	torrentOverride, err := CompileTorrentOverride(TorrentOverrideConfigStruct { Name: "ISO", Category: "ISO", Config: map[string]interface{} { "banTime": 600, "ignoreByDownloaded": 1 } })
+	if err != nil {
+		t.Fatalf("CompileTorrentOverride: %v", err)
+	}
+
+	lastBlockListCompiled := blockListCompiled
+	lastBlockPeerMap := blockPeerMap
+	lastBlockCIDRMap := blockCIDRMap
+	blockListCompiled = []*regexp.Regexp { regexp.MustCompile("(?i)Xunlei") }
+	blockPeerMap = make(map[string]BlockPeerInfoStruct)
+	blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
+	lastTimestamp := currentTimestamp
+	currentTimestamp = 1700000000
+	defer func() {
+		blockListCompiled = lastBlockListCompiled
+		blockPeerMap = lastBlockPeerMap
+		blockCIDRMap = lastBlockCIDRMap
+		currentTimestamp = lastTimestamp
+		lastCleanTimestamp = 0
+	}()
+
+	globalBanTime := config.BanTime
+	torrentConfig := GetTorrentConfig(torrentOverride)
+	if torrentConfig == &config || torrentConfig.BanTime != 600 || torrentConfig.IgnoreByDownloaded != 1 {
+		t.Fatalf("GetTorrentConfig: BanTime = %d, IgnoreByDownloaded = %d", torrentConfig.BanTime, torrentConfig.IgnoreByDownloaded)
+	}
+	if GetTorrentConfig(nil) != &config {
+		t.Error("GetTorrentConfig(nil): expected global config")
+	}
+
+	if peerStatus, _, _ := CheckPeer("203.0.113.7", 6881, "-XL0019-", "Xunlei 0019", 10240, 10240, 0.5, 0, 0, false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false, torrentConfig); peerStatus != 1 {
+		t.Fatalf("CheckPeer (override) = %d, want 1", peerStatus)
+	}
+	if peerStatus, _, _ := CheckPeer("203.0.113.8", 6881, "-XL0019-", "Xunlei 0019", 10240, 10240, 0.5, 0, 0, false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false, &config); peerStatus != 1 {
+		t.Fatalf("CheckPeer (global) = %d, want 1", peerStatus)
+	}
+
+	if config.BanTime != globalBanTime {
+		t.Errorf("global BanTime = %d, want %d", config.BanTime, globalBanTime)
+	}
+	if banTime := blockPeerMap["203.0.113.7"].BanTime; banTime != 600 {
+		t.Errorf("BanTime (override) = %d, want 600", banTime)
+	}
+	if banTime := blockPeerMap["203.0.113.8"].BanTime; banTime != int64(globalBanTime) {
+		t.Errorf("BanTime (global) = %d, want %d", banTime, globalBanTime)
+	}
+
+	// 超过覆盖配置的封禁时长后, 即使全局封禁时长更长, 也应解除封禁.
+	lastCleanTimestamp = 0
+	currentTimestamp += 601
+	ClearBlockPeer()
+	if _, exist := blockPeerMap["203.0.113.7"]; exist {
+		t.Error("peer banned with override banTime was not cleared")
+	}
+	if _, exist := blockPeerMap["203.0.113.8"]; !exist {
+		t.Error("peer banned with global banTime was cleared")
+	}
+}
+func TestTorrentOverrideConfigKeys(t *testing.T) {
+	// 全局编译的配置项 (如 blockList/rules) 按 Torrent 覆盖无效, 应被拒绝.
+	testCases := []struct {
+		config map[string]interface{}
+		valid  bool
+	} {
+		{ map[string]interface{} { "banByPUStartMB": 200, "BANTIME": 600 }, true },
+		{ map[string]interface{} { "banByPUStartMB": 200, "blockList": []string { "Xunlei" } }, false },
+		{ map[string]interface{} { "ipBlockList": []string { "203.0.113.0/24" } }, false },
+		{ map[string]interface{} { "portBlockList": []int { 6881 } }, false },
+		{ map[string]interface{} { "rules": []interface{} {} }, false },
+	}
+
+	for _, testCase := range testCases {
+		torrentOverrideConfig := TorrentOverrideConfigStruct { Name: "ISO", Category: "ISO", Config: testCase.config }
+		if _, err := CompileTorrentOverride(torrentOverrideConfig); (err == nil) != testCase.valid {
+			t.Errorf("CompileTorrentOverride(%v): err = %v, want valid %t", testCase.config, err, testCase.valid)
+		}
+
+		cfg := DeepCopyConfig(config)
+		cfg.TorrentOverrides = []TorrentOverrideConfigStruct { torrentOverrideConfig }
+		hasIssue := false
+		for _, issue := range ValidateConfigValues(cfg, true) {
+			if strings.HasPrefix(issue.Key, "torrentoverrides[0]") {
+				hasIssue = true
+			}
+		}
+		if hasIssue == testCase.valid {
+			t.Errorf("ValidateConfigValues(%v): issue = %t, want %t", testCase.config, hasIssue, !testCase.valid)
+		}
+	}
+}
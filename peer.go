@@ -7,16 +7,22 @@ import (
 )
 
 type PeerInfoStruct struct {
-	Net        *net.IPNet
-	Port       map[int]bool
-	Progress   float64
-	Downloaded int64
-	Uploaded   int64
+	Net              *net.IPNet
+	Port             map[int]bool
+	Progress         float64
+	Downloaded       int64
+	Uploaded         int64
+	Estimated        bool
+	FirstSeen        int64
+	StagnantCycles   uint32
+	StagnantUploaded int64
 }
 type BlockPeerInfoStruct struct {
 	Timestamp int64
 	Port      map[int]bool
 	InfoHash  string
+	BanTime   int64
+	GeoIP     *GeoIPInfoStruct
 }
 
 var lastCleanTimestamp int64 = 0
@@ -24,18 +30,41 @@ var blockPeerMap = make(map[string]BlockPeerInfoStruct)
 var blockCIDRMap = make(map[string]BlockCIDRInfoStruct)
 
 func AddBlockPeer(peerIP string, peerPort int, torrentInfoHash string) {
+	AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, 0)
+}
+func AddBlockPeerWithBanTime(peerIP string, peerPort int, torrentInfoHash string, banTime int64) {
+	// banTime 为 0 时使用 config.BanTime; 若已存在更长的封禁时长, 则保留.
+	if dryRunMode {
+		return
+	}
+
+	// 记录实际的封禁时长, 以便 Torrent 覆盖配置中的 banTime 在解除封禁时同样生效.
+	if banTime <= 0 {
+		banTime = int64(config.BanTime)
+	}
+
 	var blockPeerPortMap map[int]bool
 	if blockPeer, exist := blockPeerMap[peerIP]; !exist {
 		blockPeerPortMap = make(map[int]bool)
 	} else {
 		blockPeerPortMap = blockPeer.Port
+		if blockPeer.BanTime > banTime {
+			banTime = blockPeer.BanTime
+		}
 	}
 
 	blockPeerPortMap[peerPort] = true
-	blockPeerMap[peerIP] = BlockPeerInfoStruct { Timestamp: currentTimestamp, Port: blockPeerPortMap, InfoHash: torrentInfoHash }
+	geoIPInfo := GetGeoIPInfo(peerIP)
+	blockPeerMap[peerIP] = BlockPeerInfoStruct { Timestamp: currentTimestamp, Port: blockPeerPortMap, InfoHash: torrentInfoHash, BanTime: banTime, GeoIP: geoIPInfo }
 
-	peerNet := ParseIPCIDRByConfig(peerIP)
-	if peerNet != nil {
+	if geoIPInfo != nil {
+		Log("AddBlockPeer_GeoIP", "%s:%d (TorrentInfoHash: %s, GeoIP: %s)", true, peerIP, peerPort, torrentInfoHash, FormatGeoIPInfo(geoIPInfo))
+	}
+
+	// 启用 IP 段升级时, 仅在满足数量后由 PromoteBlockCIDR 封禁 IP 段, 否则固定按 banIPCIDR 封禁.
+	if config.CIDRPromotionCount > 0 {
+		PromoteBlockCIDR(peerIP)
+	} else if peerNet := ParseIPCIDRByConfig(peerIP); peerNet != nil {
 		peerNetStr := peerNet.String()
 		blockCIDRMap[peerNetStr] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: peerNet }
 	}
@@ -45,6 +74,13 @@ func AddBlockPeer(peerIP string, peerPort int, torrentInfoHash string) {
 		execCommand_Ban = strings.Replace(execCommand_Ban, "{peerIP}", peerIP, -1)
 		execCommand_Ban = strings.Replace(execCommand_Ban, "{peerPort}", strconv.Itoa(peerPort), -1)
 		execCommand_Ban = strings.Replace(execCommand_Ban, "{torrentInfoHash}", torrentInfoHash, -1)
+		if geoIPInfo != nil {
+			execCommand_Ban = strings.Replace(execCommand_Ban, "{peerASN}", strconv.FormatUint(uint64(geoIPInfo.ASN), 10), -1)
+			execCommand_Ban = strings.Replace(execCommand_Ban, "{peerCountry}", geoIPInfo.Country, -1)
+		} else {
+			execCommand_Ban = strings.Replace(execCommand_Ban, "{peerASN}", "0", -1)
+			execCommand_Ban = strings.Replace(execCommand_Ban, "{peerCountry}", "", -1)
+		}
 		out := ExecCommand(execCommand_Ban)
 
 		if out != nil {
@@ -57,8 +93,17 @@ func AddBlockPeer(peerIP string, peerPort int, torrentInfoHash string) {
 func ClearBlockPeer() int {
 	cleanCount := 0
 	if config.CleanInterval == 0 || (lastCleanTimestamp + int64(config.CleanInterval) < currentTimestamp) {
+		for cidrStr, blockCIDRInfo := range blockCIDRMap {
+			if blockCIDRInfo.BanTime > 0 && currentTimestamp > (blockCIDRInfo.Timestamp + blockCIDRInfo.BanTime) {
+				delete(blockCIDRMap, cidrStr)
+			}
+		}
 		for peerIP, peerInfo := range blockPeerMap {
-			if currentTimestamp > (peerInfo.Timestamp + int64(config.BanTime)) {
+			banTime := int64(config.BanTime)
+			if peerInfo.BanTime > 0 {
+				banTime = peerInfo.BanTime
+			}
+			if currentTimestamp > (peerInfo.Timestamp + banTime) {
 				cleanCount++
 				delete(blockPeerMap, peerIP)
 
@@ -76,21 +121,7 @@ func ClearBlockPeer() int {
 					}
 				}
 
-				if config.ExecCommand_Unban != "" {
-					for peerPort, _ := range peerInfo.Port {
-						execCommand_Unban := config.ExecCommand_Unban
-						execCommand_Unban = strings.Replace(execCommand_Unban, "{peerIP}", peerIP, -1)
-						execCommand_Unban = strings.Replace(execCommand_Unban, "{peerPort}", strconv.Itoa(peerPort), -1)
-						execCommand_Unban = strings.Replace(execCommand_Unban, "{torrentInfoHash}", peerInfo.InfoHash, -1)
-						out := ExecCommand(execCommand_Unban)
-
-						if out != nil {
-							Log("AddBlockPeer", GetLangText("Success-ExecCommand"), true, out)
-						} else {
-							Log("AddBlockPeer", GetLangText("Failed-ExecCommand"), true)
-						}
-					}
-				}
+				ExecCommandUnban(peerIP, peerInfo)
 			}
 		}
 		if cleanCount != 0 {
@@ -101,6 +132,25 @@ func ClearBlockPeer() int {
 
 	return cleanCount
 }
+func ExecCommandUnban(peerIP string, peerInfo BlockPeerInfoStruct) {
+	if config.ExecCommand_Unban == "" {
+		return
+	}
+
+	for peerPort, _ := range peerInfo.Port {
+		execCommand_Unban := config.ExecCommand_Unban
+		execCommand_Unban = strings.Replace(execCommand_Unban, "{peerIP}", peerIP, -1)
+		execCommand_Unban = strings.Replace(execCommand_Unban, "{peerPort}", strconv.Itoa(peerPort), -1)
+		execCommand_Unban = strings.Replace(execCommand_Unban, "{torrentInfoHash}", peerInfo.InfoHash, -1)
+		out := ExecCommand(execCommand_Unban)
+
+		if out != nil {
+			Log("AddBlockPeer", GetLangText("Success-ExecCommand"), true, out)
+		} else {
+			Log("AddBlockPeer", GetLangText("Failed-ExecCommand"), true)
+		}
+	}
+}
 func IsBlockedPeer(peerIP string, peerPort int, updateTimestamp bool) bool {
 	if blockPeer, exist := blockPeerMap[peerIP]; exist {
 		if IsBanPort() {
@@ -121,12 +171,39 @@ func IsBlockedPeer(peerIP string, peerPort int, updateTimestamp bool) bool {
 	
 	return false
 }
-func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, peerDlSpeed int64, peerUpSpeed int64, peerProgress float64, peerDownloaded int64, peerUploaded int64, torrentInfoHash string, torrentTotalSize int64) (int, *net.IPNet) {
+func CheckPeerRules(peerIP string, peerPort int, peerID string, peerClient string, ruleContext map[string]interface{}, afterBuiltin bool, torrentInfoHash string, torrentConfig *ConfigStruct) (int, string) {
+	// 同时返回生效的规则动作. throttle 不影响返回值, 因此继续检查后续规则.
+	ruleAction := ""
+	for _, rule := range MatchRules(ruleContext, afterBuiltin) {
+		if rule.Action == "allow" {
+			Log("Debug-CheckPeer_AllowPeer (Good-Rule)", "%s:%d %s|%s (TorrentInfoHash: %s, GeoIP: %s, Rule: %s)", false, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, FormatGeoIPInfo(GetGeoIPInfo(peerIP)), rule.Name)
+			return ApplyRuleAction(rule, peerIP, peerPort, torrentInfoHash, torrentConfig), rule.Action
+		}
+		// log 动作仅记录, 不封禁, 因此使用单独的模块名.
+		ruleModule := "CheckPeer_AddBlockPeer (Bad-Rule)"
+		if rule.Action == "log" {
+			ruleModule = "CheckPeer_LogRule"
+		}
+		Log(ruleModule, "%s:%d %s|%s (TorrentInfoHash: %s, DecodedPeerID: %s, GeoIP: %s, Rule: %s, Action: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, FormatPeerIDInfo(DecodePeerID(peerID)), FormatGeoIPInfo(GetGeoIPInfo(peerIP)), rule.Name, rule.Action)
+		if ruleStatus := ApplyRuleAction(rule, peerIP, peerPort, torrentInfoHash, torrentConfig); ruleStatus != 0 {
+			return ruleStatus, rule.Action
+		}
+		if rule.Action == "throttle" {
+			ruleAction = rule.Action
+		}
+	}
+
+	return 0, ruleAction
+}
+func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, peerDlSpeed int64, peerUpSpeed int64, peerProgress float64, peerDownloaded int64, peerUploaded int64, peerEstimated bool, torrentInfoHash string, torrentTotalSize int64, torrentTracker string, torrentPrivate bool, torrentConfig *ConfigStruct) (int, *net.IPNet, string) {
+	// ruleAction 为生效的规则动作 (如 throttle), 无规则生效时为空.
+	ruleAction := ""
+
 	if peerIP == "" || CheckPrivateIP(peerIP) || (peerDlSpeed <= 0 && peerUpSpeed <= 0) {
-		return -1, nil
+		return -1, nil, ruleAction
 	}
 
-	if IsBlockedPeer(peerIP, peerPort, true) {
+	if IsBlockedPeer(peerIP, peerPort, !dryRunMode) {
 		Log("Debug-CheckPeer_IgnorePeer (Blocked)", "%s:%d %s|%s", false, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient))
 		/*
 		if peerPort == -2 {
@@ -134,37 +211,44 @@ func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, pe
 		}
 		*/
 		if peerPort == -1 {
-			return 3, nil
+			return 3, nil, ruleAction
 		}
-		return 2, nil
+		return 2, nil, ruleAction
 	}
 
-	for port := range config.PortBlockList {
-		if port == peerPort {
-			Log("CheckPeer_AddBlockPeer (Bad-Port)", "%s:%d %s|%s (TorrentInfoHash: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash)
-			AddBlockPeer(peerIP, peerPort, torrentInfoHash)
-			return 1, nil
+	var ruleContext map[string]interface{}
+	var ruleStatus int
+	if len(rulesCompiled) > 0 {
+		ruleContext = NewPeerRuleContext(peerIP, peerPort, peerID, peerClient, peerDlSpeed, peerUpSpeed, peerProgress, peerDownloaded, peerUploaded, peerEstimated, torrentInfoHash, torrentTotalSize, torrentTracker, torrentPrivate)
+		if ruleStatus, ruleAction = CheckPeerRules(peerIP, peerPort, peerID, peerClient, ruleContext, false, torrentInfoHash, torrentConfig); ruleStatus != 0 {
+			return ruleStatus, ParseIPCIDRByConfig(peerIP), ruleAction
 		}
 	}
 
+	if portBlock := MatchPortBlockList(peerIP, peerPort, peerClient); portBlock != nil {
+		Log("CheckPeer_AddBlockPeer (Bad-Port)", "%s:%d %s|%s (TorrentInfoHash: %s, Port: %s, IPVersion: %d, Client: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, portBlock.Port, portBlock.IPVersion, portBlock.PortBlockConfigStruct.Client)
+		AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, int64(torrentConfig.BanTime))
+		return 1, nil, ruleAction
+	}
+
 	matchCIDR, peerNet := IsMatchCIDR(peerIP)
 	if matchCIDR {
 		Log("CheckPeer_AddBlockPeer (Bad-CIDR)", "%s:%d %s|%s (TorrentInfoHash: %s, Net: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, peerNet.String())
-		AddBlockPeer(peerIP, peerPort, torrentInfoHash)
-		return 1, peerNet
+		AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, int64(torrentConfig.BanTime))
+		return 1, peerNet, ruleAction
 	}
 
 	hasPeerClient := (peerID != "" || peerClient != "")
 	ignoreByDownloaded := false
 	// 若启用忽略且遇到空信息 Peer, 则既不会启用绝对进度屏蔽, 也不会记录 IP 及 Torrent 信息.
-	if (!config.IgnoreEmptyPeer || hasPeerClient) {
-		if (peerDownloaded / 1024 / 1024) >= int64(config.IgnoreByDownloaded) {
+	if (!torrentConfig.IgnoreEmptyPeer || hasPeerClient) {
+		if (peerDownloaded / 1024 / 1024) >= int64(torrentConfig.IgnoreByDownloaded) {
 			ignoreByDownloaded = true
 		}
-		if !ignoreByDownloaded && IsProgressNotMatchUploaded(torrentTotalSize, peerProgress, peerUploaded) {
-			Log("CheckPeer_AddBlockPeer (Bad-Progress_Uploaded)", "%s:%d %s|%s (TorrentInfoHash: %s, TorrentTotalSize: %.2f MB, Progress: %.2f%%, Uploaded: %.2f MB)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, (float64(torrentTotalSize) / 1024 / 1024), (peerProgress * 100), (float64(peerUploaded) / 1024 / 1024))
-			AddBlockPeer(peerIP, peerPort, torrentInfoHash)
-			return 1, peerNet
+		if !ignoreByDownloaded && IsProgressNotMatchUploaded(torrentTotalSize, peerProgress, peerUploaded, torrentConfig) {
+			Log("CheckPeer_AddBlockPeer (Bad-Progress_Uploaded)", "%s:%d %s|%s (TorrentInfoHash: %s, TorrentTotalSize: %.2f MB, Progress: %.2f%%, Uploaded: %.2f MB, Estimated: %t)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, (float64(torrentTotalSize) / 1024 / 1024), (peerProgress * 100), (float64(peerUploaded) / 1024 / 1024), peerEstimated)
+			AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, int64(torrentConfig.BanTime))
+			return 1, peerNet, ruleAction
 		}
 	}
 
@@ -174,9 +258,9 @@ func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, pe
 				continue
 			}
 			if (peerClient != "" && v.MatchString(peerClient)) || (peerID != "" && v.MatchString(peerID)) {
-				Log("CheckPeer_AddBlockPeer (Bad-Client_Normal)", "%s:%d %s|%s (TorrentInfoHash: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash)
-				AddBlockPeer(peerIP, peerPort, torrentInfoHash)
-				return 1, peerNet
+				Log("CheckPeer_AddBlockPeer (Bad-Client_Normal)", "%s:%d %s|%s (TorrentInfoHash: %s, DecodedPeerID: %s, Rule: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, FormatPeerIDInfo(DecodePeerID(peerID)), v.String())
+				AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, int64(torrentConfig.BanTime))
+				return 1, peerNet, ruleAction
 			}
 		}
 		for _, v := range blockListFromURLCompiled {
@@ -184,9 +268,17 @@ func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, pe
 				continue
 			}
 			if (peerClient != "" && v.MatchString(peerClient)) || (peerID != "" && v.MatchString(peerID)) {
-				Log("CheckPeer_AddBlockPeer (Bad-Client_List)", "%s:%d %s|%s (TorrentInfoHash: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash)
-				AddBlockPeer(peerIP, peerPort, torrentInfoHash)
-				return 1, peerNet
+				Log("CheckPeer_AddBlockPeer (Bad-Client_List)", "%s:%d %s|%s (TorrentInfoHash: %s, DecodedPeerID: %s, Rule: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, FormatPeerIDInfo(DecodePeerID(peerID)), v.String())
+				AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, int64(torrentConfig.BanTime))
+				return 1, peerNet, ruleAction
+			}
+		}
+		if torrentConfig.BanByPeerIDMismatch && peerID != "" && peerClient != "" {
+			// 吸血客户端常伪装客户端名称, 但 Peer ID 仍保留原有的客户端代码.
+			if mismatch, peerIDInfo, clientFamily := IsPeerIDMismatchClient(peerID, peerClient); mismatch {
+				Log("CheckPeer_AddBlockPeer (Bad-Client_Spoof)", "%s:%d %s|%s (TorrentInfoHash: %s, DecodedPeerID: %s, ClientFamily: %s)", true, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, FormatPeerIDInfo(peerIDInfo), clientFamily)
+				AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, int64(torrentConfig.BanTime))
+				return 1, peerNet, ruleAction
 			}
 		}
 	}
@@ -201,8 +293,8 @@ func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, pe
 			}
 			if v.Contains(ip) {
 				Log("CheckPeer_AddBlockPeer (Bad-IP_Normal)", "%s:%d %s|%s (TorrentInfoHash: %s)", true, peerIP, -1, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash)
-				AddBlockPeer(peerIP, -1, torrentInfoHash)
-				return 3, peerNet
+				AddBlockPeerWithBanTime(peerIP, -1, torrentInfoHash, int64(torrentConfig.BanTime))
+				return 3, peerNet, ruleAction
 			}
 		}
 		for _, v := range ipBlockListFromURLCompiled {
@@ -211,23 +303,34 @@ func CheckPeer(peerIP string, peerPort int, peerID string, peerClient string, pe
 			}
 			if v.Contains(ip) {
 				Log("CheckPeer_AddBlockPeer (Bad-IP_Filter)", "%s:%d %s|%s (TorrentInfoHash: %s)", true, peerIP, -1, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash)
-				AddBlockPeer(peerIP, -1, torrentInfoHash)
-				return 3, peerNet
+				AddBlockPeerWithBanTime(peerIP, -1, torrentInfoHash, int64(torrentConfig.BanTime))
+				return 3, peerNet, ruleAction
 			}
 		}
 	}
 
-	if (config.IgnoreEmptyPeer && !hasPeerClient) || ignoreByDownloaded {
-		return -2, peerNet
+	if ruleContext != nil {
+		afterRuleStatus, afterRuleAction := CheckPeerRules(peerIP, peerPort, peerID, peerClient, ruleContext, true, torrentInfoHash, torrentConfig)
+		if afterRuleAction != "" {
+			ruleAction = afterRuleAction
+		}
+		if afterRuleStatus != 0 {
+			return afterRuleStatus, peerNet, ruleAction
+		}
+	}
+
+	if (torrentConfig.IgnoreEmptyPeer && !hasPeerClient) || ignoreByDownloaded {
+		return -2, peerNet, ruleAction
 	}
 
-	return 0, peerNet
+	return 0, peerNet, ruleAction
 }
-func ProcessPeer(peerIP string, peerPort int, peerID string, peerClient string, peerDlSpeed int64, peerUpSpeed int64, peerProgress float64, peerDownloaded int64, peerUploaded int64, torrentInfoHash string, torrentTotalSize int64, blockCount *int, ipBlockCount *int, badPeersCount *int, emptyPeersCount *int) {
+func ProcessPeer(peerIP string, peerPort int, peerID string, peerClient string, peerDlSpeed int64, peerUpSpeed int64, peerProgress float64, peerDownloaded int64, peerUploaded int64, peerEstimated bool, torrentInfoHash string, torrentTotalSize int64, torrentTracker string, torrentPrivate bool, torrentConfig *ConfigStruct, blockCount *int, ipBlockCount *int, badPeersCount *int, emptyPeersCount *int) {
 	peerIP = ProcessIP(peerIP)
-	peerStatus, peerNet := CheckPeer(peerIP, peerPort, peerID, peerClient, peerDlSpeed, peerUpSpeed, peerProgress, peerDownloaded, peerUploaded, torrentInfoHash, torrentTotalSize)
+	SeenThrottlePeer(peerIP, torrentInfoHash)
+	peerStatus, peerNet, _ := CheckPeer(peerIP, peerPort, peerID, peerClient, peerDlSpeed, peerUpSpeed, peerProgress, peerDownloaded, peerUploaded, peerEstimated, torrentInfoHash, torrentTotalSize, torrentTracker, torrentPrivate, torrentConfig)
 	if config.Debug_CheckPeer {
-		Log("Debug-CheckPeer", "%s:%d %s|%s (TorrentInfoHash: %s, TorrentTotalSize: %d, PeerDlSpeed: %.2f%% MB/s, PeerUpSpeed: %.2f%% MB/s, Progress: %.2f%%, Downloaded: %.2f MB, Uploaded: %.2f MB, PeerStatus: %d)", false, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, torrentTotalSize, (float64(peerDlSpeed) / 1024 / 1024), (float64(peerUpSpeed) / 1024 / 1024), (peerProgress * 100), (float64(peerDownloaded) / 1024 / 1024), (float64(peerUploaded) / 1024 / 1024), peerStatus)
+		Log("Debug-CheckPeer", "%s:%d %s|%s (TorrentInfoHash: %s, TorrentTotalSize: %d, PeerDlSpeed: %.2f%% MB/s, PeerUpSpeed: %.2f%% MB/s, Progress: %.2f%%, Downloaded: %.2f MB, Uploaded: %.2f MB, Estimated: %t, PeerStatus: %d)", false, peerIP, peerPort, strconv.QuoteToASCII(peerID), strconv.QuoteToASCII(peerClient), torrentInfoHash, torrentTotalSize, (float64(peerDlSpeed) / 1024 / 1024), (float64(peerUpSpeed) / 1024 / 1024), (peerProgress * 100), (float64(peerDownloaded) / 1024 / 1024), (float64(peerUploaded) / 1024 / 1024), peerEstimated, peerStatus)
 	}
 
 	switch peerStatus {
@@ -239,13 +342,16 @@ func ProcessPeer(peerIP string, peerPort int, peerID string, peerClient string,
 			*badPeersCount++
 		case -2:
 			*emptyPeersCount++
+		case -3:
+			// 被规则放行的 Peer 不记录 IP 及 Torrent 信息.
 		case 0:
+			AddCorrelationInfo(peerIP, torrentInfoHash, peerProgress)
 			if peerNet == nil {
 				AddIPInfo(nil, peerIP, peerPort, torrentInfoHash, peerUploaded)
-				AddTorrentInfo(torrentInfoHash, torrentTotalSize, nil, peerIP, peerPort, peerProgress, peerUploaded)
+				AddTorrentInfo(torrentInfoHash, torrentTotalSize, nil, peerIP, peerPort, peerProgress, peerUploaded, peerEstimated, torrentConfig)
 			} else {
 				AddIPInfo(peerNet, peerNet.String(), peerPort, torrentInfoHash, peerUploaded)
-				AddTorrentInfo(torrentInfoHash, torrentTotalSize, peerNet, peerNet.String(), peerPort, peerProgress, peerUploaded)
+				AddTorrentInfo(torrentInfoHash, torrentTotalSize, peerNet, peerNet.String(), peerPort, peerProgress, peerUploaded, peerEstimated, torrentConfig)
 			}
 	}
 }
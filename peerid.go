@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strings"
+	"strconv"
+	"unicode"
+)
+
+type PeerIDInfoStruct struct {
+	Style   string
+	Code    string
+	Family  string
+	Version string
+}
+
+// Azureus 风格 (-XX1234-) 的客户端代码, 仅收录常见及常见吸血客户端.
+var peerIDAzureusFamilyMap = map[string]string {
+	"qB": "qBittorrent",
+	"TR": "Transmission",
+	"UT": "µTorrent",
+	"UM": "µTorrent",
+	"UW": "µTorrent Web",
+	"BT": "BitTorrent",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"DE": "Deluge",
+	"AZ": "Vuze",
+	"BI": "BiglyBT",
+	"KT": "KTorrent",
+	"FD": "Free Download Manager",
+	"PI": "PicoTorrent",
+	"LR": "LibreTorrent",
+	"TL": "Tribler",
+	"WW": "WebTorrent",
+	"WD": "WebTorrent Desktop",
+	"BC": "BitComet",
+	"BN": "Baidu Netdisk",
+	"XL": "Xunlei",
+	"SD": "Xunlei",
+	"DL": "Xunlei",
+	"XF": "Xfplay",
+	"QD": "QQDownload",
+	"SP": "BitSpirit",
+	"HP": "hp/torrent",
+	"DT": "dt/torrent",
+	"GT": "go.torrent",
+	"AN": "Ares",
+}
+
+// Shadow 风格 (首字母 + 版本) 的客户端代码.
+var peerIDShadowFamilyMap = map[string]string {
+	"A": "ABC",
+	"O": "Osprey Permaseed",
+	"Q": "BTQueue",
+	"R": "Tribler",
+	"S": "Shadow",
+	"T": "BitTornado",
+	"U": "UPnP NAT Bit Torrent",
+}
+
+// 客户端名称别名, 用于将对方宣称的客户端名称归一化为与 Peer ID 解码结果相同的家族名称.
+var peerClientAliasList = [][]string {
+	{ "µtorrent web", "µTorrent Web" },
+	{ "μtorrent web", "µTorrent Web" },
+	{ "utorrent web", "µTorrent Web" },
+	{ "µtorrent", "µTorrent" },
+	{ "μtorrent", "µTorrent" },
+	{ "utorrent", "µTorrent" },
+	{ "qbittorrent", "qBittorrent" },
+	{ "transmission", "Transmission" },
+	{ "deluge", "Deluge" },
+	{ "azureus", "Vuze" },
+	{ "vuze", "Vuze" },
+	{ "biglybt", "BiglyBT" },
+	{ "ktorrent", "KTorrent" },
+	{ "rtorrent", "rTorrent" },
+	{ "picotorrent", "PicoTorrent" },
+	{ "libretorrent", "LibreTorrent" },
+	{ "bitcomet", "BitComet" },
+	{ "xunlei", "Xunlei" },
+	{ "thunder", "Xunlei" },
+	{ "xfplay", "Xfplay" },
+	{ "qqdownload", "QQDownload" },
+	{ "baidunetdisk", "Baidu Netdisk" },
+	{ "bitspirit", "BitSpirit" },
+}
+
+func DecodePeerIDVersionChar(c byte) string {
+	if c >= '0' && c <= '9' {
+		return string(c)
+	}
+	if c >= 'A' && c <= 'Z' {
+		return strconv.Itoa(int(c - 'A') + 10)
+	}
+	if c >= 'a' && c <= 'z' {
+		return strconv.Itoa(int(c - 'a') + 36)
+	}
+	if c == '.' {
+		return "62"
+	}
+	if c == '-' {
+		return "63"
+	}
+
+	return ""
+}
+func DecodePeerIDVersion(versionStr string) string {
+	versionArr := []string {}
+	for k := 0; k < len(versionStr); k++ {
+		versionPart := DecodePeerIDVersionChar(versionStr[k])
+		if versionPart == "" {
+			break
+		}
+		versionArr = append(versionArr, versionPart)
+	}
+
+	// 去除末尾多余的 0, 但至少保留两段.
+	for len(versionArr) > 2 && versionArr[len(versionArr) - 1] == "0" {
+		versionArr = versionArr[:len(versionArr) - 1]
+	}
+
+	return strings.Join(versionArr, ".")
+}
+func DecodePeerID(peerID string) *PeerIDInfoStruct {
+	if len(peerID) < 8 {
+		return nil
+	}
+
+	// Azureus 风格: -XX1234-.
+	if peerID[0] == '-' && peerID[7] == '-' {
+		code := peerID[1:3]
+		family, exist := peerIDAzureusFamilyMap[code]
+		if !exist {
+			family = ""
+		}
+		return &PeerIDInfoStruct { Style: "Azureus", Code: code, Family: family, Version: DecodePeerIDVersion(peerID[3:7]) }
+	}
+
+	// Mainline 风格: M4-3-6--.
+	if peerID[0] == 'M' && unicode.IsDigit(rune(peerID[1])) {
+		versionStr := strings.Trim(peerID[1:8], "-")
+		return &PeerIDInfoStruct { Style: "Mainline", Code: "M", Family: "BitTorrent", Version: strings.Replace(strings.Replace(versionStr, "--", ".", -1), "-", ".", -1) }
+	}
+
+	// Shadow 风格: 首字母 + 最多 5 位版本, 以 - 填充.
+	code := peerID[0:1]
+	if family, exist := peerIDShadowFamilyMap[code]; exist {
+		versionStr := strings.SplitN(peerID[1:6], "-", 2)[0]
+		if versionStr != "" && strings.Trim(peerID[1:6], "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz.-") == "" {
+			return &PeerIDInfoStruct { Style: "Shadow", Code: code, Family: family, Version: DecodePeerIDVersion(versionStr) }
+		}
+	}
+
+	return nil
+}
+func FormatPeerIDInfo(peerIDInfo *PeerIDInfoStruct) string {
+	if peerIDInfo == nil {
+		return "Unknown"
+	}
+
+	family := peerIDInfo.Family
+	if family == "" {
+		family = "Unknown"
+	}
+
+	return family + " " + peerIDInfo.Version + " (" + peerIDInfo.Style + ", " + peerIDInfo.Code + ")"
+}
+func GetPeerClientFamily(peerClient string) string {
+	normalizedClient := strings.ToLower(strings.TrimLeft(peerClient, " "))
+	for _, peerClientAlias := range peerClientAliasList {
+		if strings.HasPrefix(normalizedClient, peerClientAlias[0]) {
+			return peerClientAlias[1]
+		}
+	}
+
+	return ""
+}
+func IsPeerIDMismatchClient(peerID string, peerClient string) (bool, *PeerIDInfoStruct, string) {
+	peerIDInfo := DecodePeerID(peerID)
+	if peerIDInfo == nil || peerIDInfo.Family == "" {
+		return false, peerIDInfo, ""
+	}
+
+	clientFamily := GetPeerClientFamily(peerClient)
+	if clientFamily == "" || clientFamily == peerIDInfo.Family {
+		return false, peerIDInfo, clientFamily
+	}
+
+	// µTorrent 及其 Web 版本共用名称前缀, 视为同一家族.
+	if strings.HasPrefix(clientFamily, "µTorrent") && strings.HasPrefix(peerIDInfo.Family, "µTorrent") {
+		return false, peerIDInfo, clientFamily
+	}
+
+	return true, peerIDInfo, clientFamily
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"strconv"
+	"encoding/json"
+)
+
+type PortBlockConfigStruct struct {
+	Port      string
+	IPVersion uint8
+	Client    string
+}
+type PortBlockStruct struct {
+	PortBlockConfigStruct
+	ClientRegexp *regexp.Regexp
+}
+
+var portBlockListCompiled []*PortBlockStruct
+
+func (portBlockConfig *PortBlockConfigStruct) UnmarshalJSON(data []byte) error {
+	// 兼容旧格式的数字 (6881), 亦支持字符串形式的端口范围 ("6881-6889") 及带作用域的对象.
+	var port uint32
+	if err := json.Unmarshal(data, &port); err == nil {
+		*portBlockConfig = PortBlockConfigStruct { Port: strconv.FormatUint(uint64(port), 10) }
+		return nil
+	}
+
+	var portStr string
+	if err := json.Unmarshal(data, &portStr); err == nil {
+		*portBlockConfig = PortBlockConfigStruct { Port: portStr }
+		return nil
+	}
+
+	// 对象中的端口同样允许为数字或字符串.
+	var portBlockConfigObject struct {
+		Port      json.RawMessage
+		IPVersion uint8
+		Client    string
+	}
+	if err := json.Unmarshal(data, &portBlockConfigObject); err != nil {
+		return err
+	}
+
+	*portBlockConfig = PortBlockConfigStruct { IPVersion: portBlockConfigObject.IPVersion, Client: portBlockConfigObject.Client }
+	if err := json.Unmarshal(portBlockConfigObject.Port, &port); err == nil {
+		portBlockConfig.Port = strconv.FormatUint(uint64(port), 10)
+	} else if err := json.Unmarshal(portBlockConfigObject.Port, &portStr); err == nil {
+		portBlockConfig.Port = portStr
+	} else {
+		return errors.New("port must be a number or string: " + string(data))
+	}
+
+	return nil
+}
+func CompilePortBlock(portBlockConfig PortBlockConfigStruct) (*PortBlockStruct, error) {
+	if StrTrim(portBlockConfig.Port) == "" {
+		return nil, errors.New("empty port")
+	}
+
+	for _, rangeStr := range strings.Split(portBlockConfig.Port, ",") {
+		rangeArr := strings.SplitN(StrTrim(rangeStr), "-", 2)
+		ports := []int {}
+		for _, portStr := range rangeArr {
+			port, err := strconv.Atoi(StrTrim(portStr))
+			if err != nil || port < 0 || port > 65535 {
+				return nil, errors.New("bad port: " + rangeStr)
+			}
+			ports = append(ports, port)
+		}
+		// 起始端口大于结束端口的范围永远不会匹配.
+		if len(ports) == 2 && ports[0] > ports[1] {
+			return nil, errors.New("reversed port range: " + rangeStr)
+		}
+	}
+
+	if portBlockConfig.IPVersion != 0 && portBlockConfig.IPVersion != 4 && portBlockConfig.IPVersion != 6 {
+		return nil, errors.New("bad ipVersion: " + strconv.Itoa(int(portBlockConfig.IPVersion)))
+	}
+
+	portBlock := &PortBlockStruct { PortBlockConfigStruct: portBlockConfig }
+
+	if portBlockConfig.Client != "" {
+		reg, err := regexp.Compile("(?i)" + portBlockConfig.Client)
+		if err != nil {
+			return nil, err
+		}
+		portBlock.ClientRegexp = reg
+	}
+
+	return portBlock, nil
+}
+func CompilePortBlockList() {
+	portBlockListCompiled = []*PortBlockStruct {}
+
+	for _, portBlockConfig := range config.PortBlockList {
+		Log("Debug-LoadConfig_CompilePortBlockList", "%s (IPVersion: %d, Client: %s)", false, portBlockConfig.Port, portBlockConfig.IPVersion, portBlockConfig.Client)
+
+		portBlock, err := CompilePortBlock(portBlockConfig)
+		if err != nil {
+			Log("LoadConfig_CompilePortBlockList", GetLangText("Error-CompilePortBlockList"), true, portBlockConfig.Port, err.Error())
+			continue
+		}
+
+		portBlockListCompiled = append(portBlockListCompiled, portBlock)
+	}
+}
+func MatchPortBlockList(peerIP string, peerPort int, peerClient string) *PortBlockStruct {
+	// 端口, IP 版本及客户端 (未知客户端为空字符串) 均需匹配.
+	for _, portBlock := range portBlockListCompiled {
+		if !IsNumberInRangeList(float64(peerPort), portBlock.Port) {
+			continue
+		}
+
+		if portBlock.IPVersion != 0 && (portBlock.IPVersion == 6) != IsIPv6(peerIP) {
+			continue
+		}
+
+		if portBlock.ClientRegexp != nil && !portBlock.ClientRegexp.MatchString(peerClient) {
+			continue
+		}
+
+		return portBlock
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"encoding/json"
+)
+
+func TestPortBlockConfigUnmarshal(t *testing.T) {
+	testCases := []struct {
+		data            string
+		portBlockConfig PortBlockConfigStruct
+	} {
+		{ `6881`, PortBlockConfigStruct { Port: "6881" } },
+		{ `"6881-6889"`, PortBlockConfigStruct { Port: "6881-6889" } },
+		{ `"6881-6889, 15000"`, PortBlockConfigStruct { Port: "6881-6889, 15000" } },
+		{ `{"port": 15000, "ipVersion": 4}`, PortBlockConfigStruct { Port: "15000", IPVersion: 4 } },
+		{ `{"port": "15000", "ipVersion": 6, "client": "^$"}`, PortBlockConfigStruct { Port: "15000", IPVersion: 6, Client: "^$" } },
+	}
+
+	for _, testCase := range testCases {
+		var portBlockConfig PortBlockConfigStruct
+		if err := json.Unmarshal([]byte(testCase.data), &portBlockConfig); err != nil {
+			t.Errorf("Unmarshal(%s): %v", testCase.data, err)
+			continue
+		}
+		if portBlockConfig != testCase.portBlockConfig {
+			t.Errorf("Unmarshal(%s) = %+v, want %+v", testCase.data, portBlockConfig, testCase.portBlockConfig)
+		}
+	}
+
+	for _, data := range []string { `true`, `[6881]`, `{"port": true}`, `{"port": [6881]}`, `{"ipVersion": 4}` } {
+		var portBlockConfig PortBlockConfigStruct
+		if err := json.Unmarshal([]byte(data), &portBlockConfig); err == nil {
+			t.Errorf("Unmarshal(%s): expected error, got %+v", data, portBlockConfig)
+		}
+	}
+}
+func TestCompilePortBlock(t *testing.T) {
+	for _, portBlockConfig := range []PortBlockConfigStruct { PortBlockConfigStruct { Port: "" }, PortBlockConfigStruct { Port: "6881-abc" }, PortBlockConfigStruct { Port: "65536" }, PortBlockConfigStruct { Port: "6889-6881" }, PortBlockConfigStruct { Port: "6881", IPVersion: 5 }, PortBlockConfigStruct { Port: "6881", Client: "(" } } {
+		if _, err := CompilePortBlock(portBlockConfig); err == nil {
+			t.Errorf("CompilePortBlock(%+v): expected error", portBlockConfig)
+		}
+	}
+}
+func TestMatchPortBlockList(t *testing.T) {
+	lastPortBlockListCompiled := portBlockListCompiled
+	defer func() {
+		portBlockListCompiled = lastPortBlockListCompiled
+	}()
+
+	portBlockListCompiled = []*PortBlockStruct {}
+	for _, portBlockConfig := range []PortBlockConfigStruct { PortBlockConfigStruct { Port: "6881" }, PortBlockConfigStruct { Port: "6890-6899, 7000" }, PortBlockConfigStruct { Port: "8000", IPVersion: 4 }, PortBlockConfigStruct { Port: "8001", IPVersion: 6 }, PortBlockConfigStruct { Port: "15000", Client: "^$" } } {
+		portBlock, err := CompilePortBlock(portBlockConfig)
+		if err != nil {
+			t.Fatalf("CompilePortBlock(%+v): %v", portBlockConfig, err)
+		}
+		portBlockListCompiled = append(portBlockListCompiled, portBlock)
+	}
+
+	testCases := []struct {
+		ip     string
+		port   int
+		client string
+		match  bool
+	} {
+		{ "203.0.113.7", 6881, "qBittorrent/4.6.0", true },
+		{ "203.0.113.7", 6882, "qBittorrent/4.6.0", false },
+		{ "203.0.113.7", 6890, "qBittorrent/4.6.0", true },
+		{ "203.0.113.7", 6899, "qBittorrent/4.6.0", true },
+		{ "203.0.113.7", 6900, "qBittorrent/4.6.0", false },
+		{ "203.0.113.7", 7000, "qBittorrent/4.6.0", true },
+		{ "203.0.113.7", 8000, "qBittorrent/4.6.0", true },
+		{ "2001:db8::1", 8000, "qBittorrent/4.6.0", false },
+		{ "203.0.113.7", 8001, "qBittorrent/4.6.0", false },
+		{ "2001:db8::1", 8001, "qBittorrent/4.6.0", true },
+		{ "203.0.113.7", 15000, "", true },
+		{ "203.0.113.7", 15000, "qBittorrent/4.6.0", false },
+	}
+
+	for _, testCase := range testCases {
+		if match := (MatchPortBlockList(testCase.ip, testCase.port, testCase.client) != nil); match != testCase.match {
+			t.Errorf("MatchPortBlockList(%q, %d, %q) = %t, want %t", testCase.ip, testCase.port, testCase.client, match, testCase.match)
+		}
+	}
+}
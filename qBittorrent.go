@@ -4,8 +4,10 @@ import (
 	"os"
 	"strings"
 	"strconv"
+	"os/user"
 	"encoding/json"
 	"net/url"
+	"path/filepath"
 )
 
 type qB_TorrentStruct struct {
@@ -13,6 +15,26 @@ type qB_TorrentStruct struct {
 	NumLeechs int64  `json:"num_leechs"`
 	TotalSize int64  `json:"total_size"`
 	Tracker   string `json:"tracker"`
+	Category  string `json:"category"`
+	Tags      string `json:"tags"`
+	Private   *bool  `json:"private"`
+	UpLimit   int64  `json:"up_limit"`
+}
+type qB_TorrentPropertiesStruct struct {
+	IsPrivate *bool `json:"is_private"`
+}
+type qB_TorrentTrackerStruct struct {
+	URL string `json:"url"`
+}
+type qB_TorrentTrackersCacheStruct struct {
+	Timestamp int64
+	Failed    bool
+	Trackers  []string
+}
+type qB_TorrentPrivateCacheStruct struct {
+	Timestamp int64
+	Failed    bool
+	Private   *bool
 }
 type qB_PeerStruct struct {
 	IP         string  `json:"ip"`
@@ -31,129 +53,298 @@ type qB_TorrentPeersStruct struct {
 }
 
 var qB_useNewBanPeersMethod = false
+var qB_torrentPrivateMap = make(map[string]qB_TorrentPrivateCacheStruct)
+var qB_torrentTrackersMap = make(map[string]qB_TorrentTrackersCacheStruct)
+var qB_torrentTrackersCacheTime int64 = 3600
+var qB_torrentMetaRetryTime int64 = 600
+var qB_torrentMetaFetchCount = 0
+var qB_torrentMetaFetchLimit = 20
+
+func qB_GetProcessConfigPaths() []string {
+	// 尝试从正在运行的 qBittorrent 进程 (如以服务用户运行的 qbittorrent-nox) 推断配置文件路径, 仅在存在 /proc 的系统中有效.
+	qBConfigFilenames := []string {}
+
+	procDirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return qBConfigFilenames
+	}
+
+	for _, procDir := range procDirs {
+		if _, err := strconv.Atoi(procDir.Name()); err != nil {
+			continue
+		}
+
+		procCmdline, err := os.ReadFile("/proc/" + procDir.Name() + "/cmdline")
+		if err != nil || len(procCmdline) < 1 {
+			continue
+		}
+
+		procArgs := strings.Split(strings.TrimRight(string(procCmdline), "\x00"), "\x00")
+		if !strings.HasPrefix(strings.ToLower(filepath.Base(procArgs[0])), "qbittorrent") {
+			continue
+		}
+
+		qBProfile := ""
+		qBConfiguration := ""
+		for k := 1; k < len(procArgs); k++ {
+			procArgArr := strings.SplitN(procArgs[k], "=", 2)
+			procArgValue := ""
+			if len(procArgArr) == 2 {
+				procArgValue = procArgArr[1]
+			} else if k + 1 < len(procArgs) {
+				procArgValue = procArgs[k + 1]
+			}
+			switch procArgArr[0] {
+				case "--profile":
+					qBProfile = procArgValue
+				case "--configuration":
+					qBConfiguration = procArgValue
+			}
+		}
+
+		qBDirName := "qBittorrent"
+		if qBConfiguration != "" {
+			qBDirName += "_" + qBConfiguration
+		}
+
+		if qBProfile != "" {
+			if !filepath.IsAbs(qBProfile) {
+				if procCwd, err := os.Readlink("/proc/" + procDir.Name() + "/cwd"); err == nil {
+					qBProfile = filepath.Join(procCwd, qBProfile)
+				}
+			}
+			qBConfigFilenames = append(qBConfigFilenames, filepath.Join(qBProfile, qBDirName, "config", "qBittorrent.ini"))
+			continue
+		}
+
+		procConfigDir := qB_GetProcessConfigDir(procDir.Name())
+		if procConfigDir != "" {
+			qBConfigFilenames = append(qBConfigFilenames, filepath.Join(procConfigDir, qBDirName, "qBittorrent.ini"))
+		}
+	}
+
+	return qBConfigFilenames
+}
+func qB_GetProcessConfigDir(pid string) string {
+	// 优先使用进程的 XDG_CONFIG_HOME, 否则使用进程用户的 ~/.config.
+	procEnviron, err := os.ReadFile("/proc/" + pid + "/environ")
+	if err == nil {
+		for _, procEnv := range strings.Split(string(procEnviron), "\x00") {
+			if strings.HasPrefix(procEnv, "XDG_CONFIG_HOME=") && procEnv != "XDG_CONFIG_HOME=" {
+				return strings.TrimPrefix(procEnv, "XDG_CONFIG_HOME=")
+			}
+		}
+	}
+
+	procStatus, err := os.ReadFile("/proc/" + pid + "/status")
+	if err != nil {
+		return ""
+	}
+
+	for _, procStatusLine := range strings.Split(string(procStatus), "\n") {
+		if !strings.HasPrefix(procStatusLine, "Uid:") {
+			continue
+		}
+
+		procUIDs := strings.Fields(strings.TrimPrefix(procStatusLine, "Uid:"))
+		if len(procUIDs) < 1 {
+			break
+		}
+
+		procUser, err := user.LookupId(procUIDs[0])
+		if err != nil || procUser.HomeDir == "" {
+			break
+		}
+
+		return filepath.Join(procUser.HomeDir, ".config")
+	}
+
+	return ""
+}
+func qB_GetConfigPaths() []string {
+	// 按顺序查找, 优先使用配置中的 clientConfigPaths (.json 为 Transmission 配置文件).
+	qBConfigFilenames := []string {}
+	for _, clientConfigPath := range config.ClientConfigPaths {
+		if !strings.HasSuffix(strings.ToLower(clientConfigPath), ".json") {
+			qBConfigFilenames = append(qBConfigFilenames, clientConfigPath)
+		}
+	}
 
-func qB_GetConfigPath() string {
-	var qBConfigFilename string
 	userHomeDir, err := os.UserHomeDir()
 	if err != nil {
 		Log("Debug-GetConfigPath", GetLangText("Error-Debug-GetConfigPath_GetUserHomeDir"), true, err.Error())
-		return ""
+		return qBConfigFilenames
 	}
+
 	if IsUnix(userHomeDir) {
-		qBConfigFilename = userHomeDir + "/.config/qBittorrent/qBittorrent.ini"
+		if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+			qBConfigFilenames = append(qBConfigFilenames, xdgConfigHome + "/qBittorrent/qBittorrent.ini")
+		}
+		qBConfigFilenames = append(qBConfigFilenames, userHomeDir + "/.config/qBittorrent/qBittorrent.ini")
+		qBConfigFilenames = append(qBConfigFilenames, qB_GetProcessConfigPaths()...)
+		qBConfigFilenames = append(qBConfigFilenames,
+			"/var/lib/qbittorrent/.config/qBittorrent/qBittorrent.ini",
+			"/var/lib/qbittorrent-nox/.config/qBittorrent/qBittorrent.ini",
+			"/home/qbittorrent/.config/qBittorrent/qBittorrent.ini",
+			"/home/qbittorrent-nox/.config/qBittorrent/qBittorrent.ini",
+			"/config/qBittorrent/qBittorrent.ini",
+			"/config/qBittorrent/config/qBittorrent.ini",
+			"/config/.config/qBittorrent/qBittorrent.ini",
+		)
 	} else {
 		userConfigDir, err := os.UserConfigDir()
 		if err != nil {
 			Log("Debug-GetConfigPath", GetLangText("Error-Debug-GetConfigPath_GetUserConfigDir"), true, err.Error())
-			return ""
+			return qBConfigFilenames
 		}
-		qBConfigFilename = userConfigDir + "\\qBittorrent\\qBittorrent.ini"
-	}
-	return qBConfigFilename
-}
-func qB_GetConfig() []byte {
-	qBConfigFilename := qB_GetConfigPath()
-	if qBConfigFilename == "" {
-		return []byte {}
+		qBConfigFilenames = append(qBConfigFilenames, userConfigDir + "\\qBittorrent\\qBittorrent.ini")
 	}
 
+	return qBConfigFilenames
+}
+func qB_GetConfig(qBConfigFilename string) ([]byte, string) {
 	_, err := os.Stat(qBConfigFilename)
 	if err != nil {
 		if !os.IsNotExist(err) {
-			// 避免反复猜测默认 qBittorrent 配置文件的失败信息影响 Debug 用户体验.
 			Log("GetConfig", GetLangText("Error-GetConfig_LoadConfigMeta"), true, err.Error())
+			return nil, err.Error()
 		}
-		return []byte {}
+		// 避免反复猜测默认 qBittorrent 配置文件的失败信息影响 Debug 用户体验.
+		return nil, ""
 	}
 
-	Log("GetConfig", GetLangText("GetConfig_UseConfig"), true, qBConfigFilename)
-
 	qBConfigFile, err := os.ReadFile(qBConfigFilename)
 	if err != nil {
 		Log("GetConfig", GetLangText("Error-GetConfig_LoadConfig"), true, err.Error())
-		return []byte {}
+		return nil, err.Error()
 	}
 
-	return qBConfigFile
+	return qBConfigFile, ""
 }
-func qB_SetURL() bool {
-	qBConfigFile := qB_GetConfig()
-	if len(qBConfigFile) < 1 {
-		return false
-	}
-	qBConfigFileArr := strings.Split(string(qBConfigFile), "\n")
-	qBWebUIEnabled := false
-	qBHTTPSEnabled := false
-	qBAddress := ""
-	qBPort := 8080
-	Username := ""
-	for _, qbConfigLine := range qBConfigFileArr {
-		qbConfigLineArr := strings.SplitN(qbConfigLine, "=", 2)
-		if len(qbConfigLineArr) < 2 || qbConfigLineArr[1] == "" {
-			continue
-		}
-		qbConfigLineArr[0] = strings.ToLower(StrTrim(qbConfigLineArr[0]))
-		qbConfigLineArr[1] = strings.ToLower(StrTrim(qbConfigLineArr[1]))
-		switch qbConfigLineArr[0] {
-			case "webui\\enabled":
-				if qbConfigLineArr[1] == "true" {
-					qBWebUIEnabled = true
-				}
-			case "webui\\https\\enabled":
-				if qbConfigLineArr[1] == "true" {
-					qBHTTPSEnabled = true
-				}
-			case "webui\\address":
-				if qbConfigLineArr[1] == "*" || qbConfigLineArr[1] == "0.0.0.0" {
-					qBAddress = "127.0.0.1"
-				} else if qbConfigLineArr[1] == "::" || qbConfigLineArr[1] == "::1" {
-					qBAddress = "[::1]"
-				} else {
-					qBAddress = qbConfigLineArr[1]
-				}
-			case "webui\\port":
-				tmpQBPort, err := strconv.Atoi(qbConfigLineArr[1])
-				if err == nil {
-					qBPort = tmpQBPort
+func qB_ParseConfigURL(qBConfigFile []byte) (string, string, string) {
+	qBConfig := ParseINI(qBConfigFile)
+
+	// qBittorrent 4.x 起配置位于 [Preferences], 旧版本的配置则可能位于其它节.
+	qBPreferences, exist := qBConfig["preferences"]
+	if !exist || qBPreferences["webui\\port"] == "" {
+		qBPreferences = make(map[string]string)
+		for _, qBSection := range qBConfig {
+			for k, v := range qBSection {
+				if strings.HasPrefix(k, "webui\\") {
+					qBPreferences[k] = v
 				}
-			case "webui\\username":
-				Username = qbConfigLineArr[1]
+			}
 		}
 	}
-	if !qBWebUIEnabled || qBAddress == "" {
-		Log("SetURL", GetLangText("Abandon-SetURL"), true, qBWebUIEnabled, qBAddress)
-		return false
+
+	if v, exist := qBPreferences["webui\\enabled"]; exist && strings.ToLower(v) != "true" {
+		return "", "", GetLangText("Abandon-SetURL_WebUIDisabled")
+	}
+
+	qBAddress := strings.ToLower(qBPreferences["webui\\address"])
+	if qBAddress == "" || qBAddress == "*" || qBAddress == "0.0.0.0" {
+		qBAddress = "127.0.0.1"
+	} else if qBAddress == "::" || qBAddress == "::1" {
+		qBAddress = "[::1]"
+	} else if IsIPv6(qBAddress) {
+		qBAddress = "[" + qBAddress + "]"
+	}
+
+	qBPort := 8080
+	if v, exist := qBPreferences["webui\\port"]; exist {
+		tmpQBPort, err := strconv.Atoi(v)
+		if err != nil || tmpQBPort <= 0 || tmpQBPort > 65535 {
+			return "", "", GetLangText("Abandon-SetURL_BadPort")
+		}
+		qBPort = tmpQBPort
 	}
-	if qBHTTPSEnabled {
-		config.ClientURL = "https://" + qBAddress
+
+	qBURL := ""
+	if strings.ToLower(qBPreferences["webui\\https\\enabled"]) == "true" {
+		qBURL = "https://" + qBAddress
 		if qBPort != 443 {
-			config.ClientURL += ":" + strconv.Itoa(qBPort)
+			qBURL += ":" + strconv.Itoa(qBPort)
 		}
 	} else {
-		config.ClientURL = "http://" + qBAddress
+		qBURL = "http://" + qBAddress
 		if qBPort != 80 {
-			config.ClientURL += ":" + strconv.Itoa(qBPort)
+			qBURL += ":" + strconv.Itoa(qBPort)
 		}
 	}
-	config.ClientUsername = Username
-	Log("SetURL", GetLangText("Success-SetURL"), true, qBWebUIEnabled, config.ClientURL, config.ClientUsername)
-	return true
+
+	// 若客户端位于反向代理之后, 此处得到的仍是直连地址; 反向代理的基础路径须手动设置 clientURL.
+	if strings.ToLower(qBPreferences["webui\\reverseproxysupportenabled"]) == "true" {
+		Log("SetURL", GetLangText("SetURL_ReverseProxy"), true, qBURL)
+	}
+
+	if strings.ToLower(qBPreferences["webui\\hostheadervalidation"]) != "false" {
+		if serverDomains := qBPreferences["webui\\serverdomains"]; serverDomains != "" && serverDomains != "*" {
+			Log("Debug-SetURL", GetLangText("Debug-SetURL_HostHeaderValidation"), false, serverDomains)
+		}
+	}
+
+	qBUsername := qBPreferences["webui\\username"]
+	if strings.ToLower(qBPreferences["webui\\localhostauth"]) == "false" && (qBAddress == "127.0.0.1" || qBAddress == "[::1]" || qBAddress == "localhost") {
+		Log("Debug-SetURL", GetLangText("Debug-SetURL_LocalHostAuthDisabled"), false)
+	} else if qBUsername != "" && config.ClientPassword == "" {
+		Log("SetURL", GetLangText("SetURL_NeedPassword"), true)
+	}
+
+	return qBURL, qBUsername, ""
+}
+func qB_SetURL() bool {
+	for _, qBConfigFilename := range qB_GetConfigPaths() {
+		qBConfigFile, rejectReason := qB_GetConfig(qBConfigFilename)
+		if qBConfigFile == nil {
+			if rejectReason == "" {
+				Log("Debug-SetURL", GetLangText("Abandon-SetURL_NotExist"), false, qBConfigFilename)
+			} else {
+				Log("SetURL", GetLangText("Abandon-SetURL_File"), true, qBConfigFilename, rejectReason)
+			}
+			continue
+		}
+
+		qBURL, qBUsername, rejectReason := qB_ParseConfigURL(qBConfigFile)
+		if qBURL == "" {
+			Log("SetURL", GetLangText("Abandon-SetURL_File"), true, qBConfigFilename, rejectReason)
+			continue
+		}
+
+		Log("GetConfig", GetLangText("GetConfig_UseConfig"), true, qBConfigFilename)
+
+		config.ClientURL = qBURL
+		config.ClientUsername = qBUsername
+		Log("SetURL", GetLangText("Success-SetURL"), true, true, config.ClientURL, config.ClientUsername)
+		return true
+	}
+
+	return false
 }
 func qB_GetAPIVersion() bool {
-	apiResponseStatusCode, _ := Fetch(config.ClientURL + "/api/v2/app/webapiVersion", false, false, nil)
-	return (apiResponseStatusCode == 200 || apiResponseStatusCode == 403)
+	_, err := DoRequest(RequestStruct { URL: config.ClientURL + "/api/v2/app/webapiVersion" })
+	if err == nil {
+		return true
+	}
+
+	// 未登录时 qBittorrent 会返回 403, 同样可以说明客户端类型.
+	if errType, statusCode, ok := GetRequestErrorType(err); ok && errType == RequestError_Auth && statusCode == 403 {
+		return true
+	}
+
+	Log("Debug-GetAPIVersion", "%s", false, err.Error())
+	return false
 }
 func qB_Login() bool {
 	loginParams := url.Values {}
 	loginParams.Set("username", config.ClientUsername)
 	loginParams.Set("password", config.ClientPassword)
-	_, loginResponseBody := Submit(config.ClientURL + "/api/v2/auth/login", loginParams.Encode(), false, true, nil)
-	if loginResponseBody == nil {
-		Log("Login", GetLangText("Error-Login"), true)
+	loginResponse, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL + "/api/v2/auth/login", Body: loginParams.Encode(), WithAuth: true })
+	if err != nil {
+		Log("Login", GetLangText("Error-Login") + ": %s", true, err.Error())
 		return false
 	}
 
-	loginResponseBodyStr := StrTrim(string(loginResponseBody))
+	loginResponseBodyStr := StrTrim(string(loginResponse.Body))
 	if loginResponseBodyStr == "Ok." {
 		Log("Login", GetLangText("Success-Login"), true)
 		return true
@@ -165,29 +356,187 @@ func qB_Login() bool {
 	return false
 }
 func qB_FetchTorrents() *[]qB_TorrentStruct {
-	_, torrentsResponseBody := Fetch(config.ClientURL + "/api/v2/torrents/info?filter=active", true, true, nil)
-	if torrentsResponseBody == nil {
-		Log("FetchTorrents", GetLangText("Error"), true)
+	torrentsResponse, err := DoRequest(RequestStruct { URL: config.ClientURL + "/api/v2/torrents/info?filter=active", WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("FetchTorrents", "%s", true, err.Error())
 		return nil
 	}
 
 	var torrentsResult []qB_TorrentStruct
-	if err := json.Unmarshal(torrentsResponseBody, &torrentsResult); err != nil {
+	if err := json.Unmarshal(torrentsResponse.Body, &torrentsResult); err != nil {
 		Log("FetchTorrents", GetLangText("Error-Parse"), true, err.Error())
 		return nil
 	}
 
 	return &torrentsResult
 }
+func qB_GetTorrentUploadLimit(infoHash string) (int64, bool) {
+	torrentsResponse, err := DoRequest(RequestStruct { URL: config.ClientURL + "/api/v2/torrents/info?hashes=" + infoHash, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("GetTorrentUploadLimit", "%s", true, err.Error())
+		return 0, false
+	}
+
+	var torrentsResult []qB_TorrentStruct
+	if err := json.Unmarshal(torrentsResponse.Body, &torrentsResult); err != nil {
+		Log("GetTorrentUploadLimit", GetLangText("Error-Parse"), true, err.Error())
+		return 0, false
+	}
+
+	if len(torrentsResult) <= 0 {
+		return 0, false
+	}
+
+	// qBittorrent 以 0 或 -1 表示不限速.
+	if torrentsResult[0].UpLimit <= 0 {
+		return -1, true
+	}
+
+	return torrentsResult[0].UpLimit, true
+}
+func qB_SetTorrentUploadLimit(infoHash string, uploadLimit int64) bool {
+	_, err := DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL + "/api/v2/torrents/setUploadLimit", Body: "hashes=" + infoHash + "&limit=" + strconv.FormatInt(uploadLimit, 10), WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("SetTorrentUploadLimit", "%s", true, err.Error())
+		return false
+	}
+
+	return true
+}
+func qB_FetchTorrentPrivate(infoHash string) (*bool, bool) {
+	torrentPropertiesResponse, err := DoRequest(RequestStruct { URL: config.ClientURL + "/api/v2/torrents/properties?hash=" + infoHash, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("FetchTorrentPrivate", "%s", true, err.Error())
+		return nil, false
+	}
+
+	var torrentPropertiesResult qB_TorrentPropertiesStruct
+	if err := json.Unmarshal(torrentPropertiesResponse.Body, &torrentPropertiesResult); err != nil {
+		Log("FetchTorrentPrivate", GetLangText("Error-Parse"), true, err.Error())
+		return nil, false
+	}
+
+	return torrentPropertiesResult.IsPrivate, true
+}
+func qB_AllowFetchTorrentMeta(torrentInfo qB_TorrentStruct) bool {
+	// 没有 Leecher 的 Torrent 不会被检查, 因此无需获取; 其余 Torrent 每个周期的请求数量亦有上限, 超出部分在后续周期获取.
+	if torrentInfo.NumLeechs <= 0 || qB_torrentMetaFetchCount >= qB_torrentMetaFetchLimit {
+		return false
+	}
+
+	qB_torrentMetaFetchCount++
+
+	return true
+}
+func qB_GetTorrentPrivate(torrentInfo qB_TorrentStruct) *bool {
+	// qBittorrent 5.0 起 torrents/info 提供 private, 较旧版本则从 properties 的 is_private 获取; 更旧的版本两者均不提供, 此时返回 nil.
+	if torrentInfo.Private != nil {
+		return torrentInfo.Private
+	}
+
+	if torrentPrivateCache, exist := qB_torrentPrivateMap[torrentInfo.InfoHash]; exist {
+		if !torrentPrivateCache.Failed {
+			// Torrent 的私有标志不会改变, 因此仅更新使用时间, 以便清理已删除的 Torrent.
+			torrentPrivateCache.Timestamp = currentTimestamp
+			qB_torrentPrivateMap[torrentInfo.InfoHash] = torrentPrivateCache
+			return torrentPrivateCache.Private
+		}
+
+		// 获取失败后, 在重试间隔内不再请求.
+		if (torrentPrivateCache.Timestamp + qB_torrentMetaRetryTime) > currentTimestamp {
+			return nil
+		}
+	}
+
+	if !qB_AllowFetchTorrentMeta(torrentInfo) {
+		return nil
+	}
+
+	torrentPrivate, ok := qB_FetchTorrentPrivate(torrentInfo.InfoHash)
+	qB_torrentPrivateMap[torrentInfo.InfoHash] = qB_TorrentPrivateCacheStruct { Timestamp: currentTimestamp, Failed: !ok, Private: torrentPrivate }
+
+	return torrentPrivate
+}
+func qB_FetchTorrentTrackers(infoHash string) []string {
+	torrentTrackersResponse, err := DoRequest(RequestStruct { URL: config.ClientURL + "/api/v2/torrents/trackers?hash=" + infoHash, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("FetchTorrentTrackers", "%s", true, err.Error())
+		return nil
+	}
+
+	var torrentTrackersResult []qB_TorrentTrackerStruct
+	if err := json.Unmarshal(torrentTrackersResponse.Body, &torrentTrackersResult); err != nil {
+		Log("FetchTorrentTrackers", GetLangText("Error-Parse"), true, err.Error())
+		return nil
+	}
+
+	// 忽略 DHT/PeX/LSD 等伪 Tracker.
+	torrentTrackers := []string {}
+	for _, torrentTracker := range torrentTrackersResult {
+		if strings.Contains(torrentTracker.URL, "://") {
+			torrentTrackers = append(torrentTrackers, torrentTracker.URL)
+		}
+	}
+
+	return torrentTrackers
+}
+func qB_GetTorrentTrackers(torrentInfo qB_TorrentStruct) []string {
+	// torrents/info 仅提供当前工作的 Tracker, 因此定期获取完整的 Tracker 列表.
+	if torrentTrackersCache, exist := qB_torrentTrackersMap[torrentInfo.InfoHash]; exist {
+		// 获取失败后, 在重试间隔内使用当前工作的 Tracker.
+		torrentTrackersCacheTime := qB_torrentTrackersCacheTime
+		if torrentTrackersCache.Failed {
+			torrentTrackersCacheTime = qB_torrentMetaRetryTime
+		}
+		if (torrentTrackersCache.Timestamp + torrentTrackersCacheTime) > currentTimestamp {
+			return torrentTrackersCache.Trackers
+		}
+	}
+
+	fallbackTrackers := []string {}
+	if torrentInfo.Tracker != "" {
+		fallbackTrackers = append(fallbackTrackers, torrentInfo.Tracker)
+	}
+
+	if !qB_AllowFetchTorrentMeta(torrentInfo) {
+		return fallbackTrackers
+	}
+
+	torrentTrackers := qB_FetchTorrentTrackers(torrentInfo.InfoHash)
+	if torrentTrackers == nil {
+		qB_torrentTrackersMap[torrentInfo.InfoHash] = qB_TorrentTrackersCacheStruct { Timestamp: currentTimestamp, Failed: true, Trackers: fallbackTrackers }
+		return fallbackTrackers
+	}
+
+	qB_torrentTrackersMap[torrentInfo.InfoHash] = qB_TorrentTrackersCacheStruct { Timestamp: currentTimestamp, Trackers: torrentTrackers }
+
+	return torrentTrackers
+}
+func qB_CleanTorrentTrackers() {
+	qB_torrentMetaFetchCount = 0
+
+	for infoHash, torrentTrackersCache := range qB_torrentTrackersMap {
+		if (torrentTrackersCache.Timestamp + qB_torrentTrackersCacheTime) <= currentTimestamp {
+			delete(qB_torrentTrackersMap, infoHash)
+		}
+	}
+
+	// 私有标志在每次使用时更新时间, 因此长时间未使用者即为已删除或不再活跃的 Torrent.
+	for infoHash, torrentPrivateCache := range qB_torrentPrivateMap {
+		if (torrentPrivateCache.Timestamp + qB_torrentTrackersCacheTime) <= currentTimestamp {
+			delete(qB_torrentPrivateMap, infoHash)
+		}
+	}
+}
 func qB_FetchTorrentPeers(infoHash string) *qB_TorrentPeersStruct {
-	_, torrentPeersResponseBody := Fetch(config.ClientURL + "/api/v2/sync/torrentPeers?rid=0&hash=" + infoHash, true, true, nil)
-	if torrentPeersResponseBody == nil {
-		Log("FetchTorrentPeers", GetLangText("Error"), true)
+	torrentPeersResponse, err := DoRequest(RequestStruct { URL: config.ClientURL + "/api/v2/sync/torrentPeers?rid=0&hash=" + infoHash, WithAuth: true, TryLogin: true })
+	if err != nil {
+		Log("FetchTorrentPeers", "%s", true, err.Error())
 		return nil
 	}
 
 	var torrentPeersResult qB_TorrentPeersStruct
-	if err := json.Unmarshal(torrentPeersResponseBody, &torrentPeersResult); err != nil {
+	if err := json.Unmarshal(torrentPeersResponse.Body, &torrentPeersResult); err != nil {
 		Log("FetchTorrentPeers", GetLangText("Error-Parse"), true, err.Error())
 		return nil
 	}
@@ -234,18 +583,18 @@ func qB_SubmitBlockPeer(blockPeerMap map[string]BlockPeerInfoStruct) bool {
 
 	Log("Debug-SubmitBlockPeer", "%s", false, banIPPortsStr)
 
-	var banResponseBody []byte
+	var err error
 
 	if qB_useNewBanPeersMethod && banIPPortsStr != "" {
 		banIPPortsStr = url.QueryEscape(banIPPortsStr)
-		_, banResponseBody = Submit(config.ClientURL + "/api/v2/transfer/banPeers", banIPPortsStr, true, true, nil)
+		_, err = DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL + "/api/v2/transfer/banPeers", Body: banIPPortsStr, WithAuth: true, TryLogin: true })
 	} else {
 		banIPPortsStr = url.QueryEscape("{\"banned_IPs\": \"" + banIPPortsStr + "\"}")
-		_, banResponseBody = Submit(config.ClientURL + "/api/v2/app/setPreferences", "json=" + banIPPortsStr, true, true, nil)
+		_, err = DoRequest(RequestStruct { Method: "POST", URL: config.ClientURL + "/api/v2/app/setPreferences", Body: "json=" + banIPPortsStr, WithAuth: true, TryLogin: true })
 	}
 
-	if banResponseBody == nil {
-		Log("SubmitBlockPeer", GetLangText("Error"), true)
+	if err != nil {
+		Log("SubmitBlockPeer", "%s", true, err.Error())
 		return false
 	}
 
@@ -1,32 +1,127 @@
 package main
 
 import (
-	"net/http"
+	"io"
+	"net"
+	"fmt"
+	"time"
+	"errors"
+	"context"
 	"strings"
-	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+)
+
+type RequestErrorType int
+
+const (
+	RequestError_Build RequestErrorType = iota
+	RequestError_Network
+	RequestError_Timeout
+	RequestError_Auth
+	RequestError_CSRF
+	RequestError_Status
+	RequestError_TooLarge
+	RequestError_CircuitOpen
 )
 
-func NewRequest(isPOST bool, url string, postdata string, withAuth bool, withHeader *map[string]string) *http.Request {
+type RequestStruct struct {
+	Method   string
+	URL      string
+	Body     string
+	Header   *map[string]string
+	WithAuth bool          // 客户端请求: 使用 Cookie/CSRF Token/Basic Auth, 并受熔断影响.
+	TryLogin bool          // 认证失败时重新登录并重试一次.
+	Timeout  time.Duration // 0 则使用 config.Timeout.
+	MaxSize  int64         // 0 则使用 requestDefaultMaxSize.
+}
+type ResponseStruct struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+type RequestError struct {
+	Type       RequestErrorType
+	RequestID  uint64
+	StatusCode int
+	MaxSize    int64
+	Err        error
+}
+
+// 客户端 (如 torrents/info) 的响应可能较大, 列表等请求应自行设置更小的限制.
+const requestDefaultMaxSize int64 = 67108864
+
+var requestID uint64 = 0
+var requestFailedCount = 0
+var requestCircuitOpenUntil int64 = 0
+
+func (e *RequestError) Error() string {
+	message := ""
+
+	switch e.Type {
+		case RequestError_Build:
+			message = fmt.Sprintf(GetLangText("Error-NewRequest"), e.Err)
+		case RequestError_Network:
+			message = fmt.Sprintf(GetLangText("Error-FetchResponse"), e.Err)
+		case RequestError_Timeout:
+			message = fmt.Sprintf(GetLangText("Error-Request_Timeout"), e.Err)
+		case RequestError_Auth:
+			if e.StatusCode == 401 {
+				message = GetLangText("Error-NoAuth")
+			} else {
+				message = GetLangText("Error-Forbidden")
+			}
+		case RequestError_CSRF:
+			message = GetLangText("Error-Request_CSRF")
+		case RequestError_Status:
+			if e.StatusCode == 404 {
+				message = GetLangText("Error-NotFound")
+			} else {
+				message = fmt.Sprintf(GetLangText("Error-UnknownStatusCode"), e.StatusCode)
+			}
+		case RequestError_TooLarge:
+			message = fmt.Sprintf(GetLangText("Error-Request_TooLarge"), e.MaxSize)
+		case RequestError_CircuitOpen:
+			message = GetLangText("Error-Request_CircuitOpenSkip")
+	}
+
+	return fmt.Sprintf("#%d %s", e.RequestID, message)
+}
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+func GetRequestErrorType(err error) (RequestErrorType, int, bool) {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.Type, requestErr.StatusCode, true
+	}
+
+	return 0, 0, false
+}
+func NewRequest(req RequestStruct, ctx context.Context) (*http.Request, error) {
 	var request *http.Request
 	var err error
 
-	if !isPOST {
-		request, err = http.NewRequest("GET", url, nil)
+	if req.Method == "" {
+		req.Method = "GET"
+	}
+
+	if req.Method == "GET" {
+		request, err = http.NewRequestWithContext(ctx, req.Method, req.URL, nil)
 	} else {
-		request, err = http.NewRequest("POST", url, strings.NewReader(postdata))
+		request, err = http.NewRequestWithContext(ctx, req.Method, req.URL, strings.NewReader(req.Body))
 	}
 
 	if err != nil {
-		Log("NewRequest", GetLangText("Error-NewRequest"), true, err.Error())
-		return nil
+		return nil, err
 	}
 
 	request.Header.Set("User-Agent", programName + "/" + programVersion)
 
 	setContentType := false
 
-	if withHeader != nil {
-		for k, v := range *withHeader {
+	if req.Header != nil {
+		for k, v := range *req.Header {
 			if strings.ToLower(k) == "content-type" {
 				setContentType = true
 			}
@@ -34,158 +129,163 @@ func NewRequest(isPOST bool, url string, postdata string, withAuth bool, withHea
 		}
 	}
 
-	if !setContentType && isPOST {
+	if !setContentType && req.Method == "POST" {
 		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
 
-	if currentClientType == "Transmission" && withAuth && Tr_csrfToken != "" {
+	if currentClientType == "Transmission" && req.WithAuth && Tr_csrfToken != "" {
 		request.Header.Set("X-Transmission-Session-Id", Tr_csrfToken)
 	}
 
-	if withAuth && config.UseBasicAuth && config.ClientUsername != "" {
+	if req.WithAuth && config.UseBasicAuth && config.ClientUsername != "" {
 		request.SetBasicAuth(config.ClientUsername, config.ClientPassword)
 	}
 
-	return request
+	return request, nil
 }
-func Fetch(url string, tryLogin bool, withCookie bool, withHeader *map[string]string) (int, []byte) {
-	request := NewRequest(false, url, "", withCookie, withHeader)
-	if request == nil {
-		return -1, nil
-	}
-
-	var response *http.Response
-	var err error
-
-	if withCookie {
-		response, err = httpClient.Do(request)
-	} else {
-		response, err = httpClientWithoutCookie.Do(request)
+func IsRequestCircuitOpen() bool {
+	return (requestCircuitOpenUntil > time.Now().Unix())
+}
+func SetRequestResult(success bool) {
+	if success {
+		if requestFailedCount >= 3 {
+			Log("Request", GetLangText("Success-Request_CircuitClose"), true)
+		}
+		requestFailedCount = 0
+		requestCircuitOpenUntil = 0
+		return
 	}
 
-	if err != nil {
-		Log("Fetch", GetLangText("Error-FetchResponse"), true, err.Error())
-		return -2, nil
-	}
+	requestFailedCount++
 
-	responseBody, err := ioutil.ReadAll(response.Body)
-	defer response.Body.Close()
-
-	if err != nil {
-		Log("Fetch", GetLangText("Error-ReadResponse"), true, err.Error())
-		return -3, nil
+	// 连续失败 3 次后暂停客户端请求, 暂停时长按指数退避 (Interval * 2^n), 最长 300 秒.
+	if requestFailedCount >= 3 {
+		backoffSecond := int64(config.Interval)
+		for k := 3; k < requestFailedCount && backoffSecond < 300; k++ {
+			backoffSecond *= 2
+		}
+		if backoffSecond > 300 {
+			backoffSecond = 300
+		}
+		requestCircuitOpenUntil = (time.Now().Unix() + backoffSecond)
+		Log("Request", GetLangText("Error-Request_CircuitOpen"), true, requestFailedCount, backoffSecond)
 	}
+}
+func DoRequest(req RequestStruct) (*ResponseStruct, error) {
+	return doRequest(req, true)
+}
+func doRequest(req RequestStruct, allowRetry bool) (*ResponseStruct, error) {
+	currentRequestID := atomic.AddUint64(&requestID, 1)
 
-	if response.StatusCode == 401 {
-		Log("Fetch", GetLangText("Error-NoAuth"), true)
-		return 401, nil
+	// 仅客户端请求受熔断影响, 以免列表及更新检查被客户端故障拖累.
+	if req.WithAuth && IsRequestCircuitOpen() {
+		Log("Debug-Request", "#%d " + GetLangText("Debug-Request_CircuitOpen"), false, currentRequestID, req.URL)
+		return nil, &RequestError { Type: RequestError_CircuitOpen, RequestID: currentRequestID }
 	}
 
-	if response.StatusCode == 403 {
-		if tryLogin {
-			Login()
-		}
-		Log("Fetch", GetLangText("Error-Forbidden"), true)
-		return 403, nil
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = (time.Duration(config.Timeout) * time.Second)
 	}
-
-	if response.StatusCode == 409 {
-		// 尝试获取并设置 CSRF Token.
-		if currentClientType == "Transmission" {
-			transmissionCSRFToken := response.Header.Get("X-Transmission-Session-Id")
-			if transmissionCSRFToken != "" {
-				Tr_SetCSRFToken(transmissionCSRFToken)
-				return 409, nil
-			}
-		}
-
-		if tryLogin {
-			Login()
-		}
-
-		Log("Fetch", GetLangText("Error-Forbidden"), true)
-		return 409, nil
+	maxSize := req.MaxSize
+	if maxSize <= 0 {
+		maxSize = requestDefaultMaxSize
 	}
 
-	if response.StatusCode == 404 {
-		Log("Fetch", GetLangText("Error-NotFound"), true)
-		return 404, nil
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if response.StatusCode != 200 {
-		Log("Fetch", GetLangText("Error-UnknownStatusCode"), true, response.StatusCode)
-		return response.StatusCode, nil
+	request, err := NewRequest(req, ctx)
+	if err != nil {
+		return nil, &RequestError { Type: RequestError_Build, RequestID: currentRequestID, Err: err }
 	}
 
-	return response.StatusCode, responseBody
-}
-func Submit(url string, postdata string, tryLogin bool, withCookie bool, withHeader *map[string]string) (int, []byte) {
-	request := NewRequest(true, url, postdata, withCookie, withHeader)
-	if request == nil {
-		return -1, nil
-	}
+	Log("Debug-Request", "#%d %s %s", false, currentRequestID, request.Method, req.URL)
 
 	var response *http.Response
-	var err error
 
-	if withCookie {
+	if req.WithAuth {
 		response, err = httpClient.Do(request)
 	} else {
 		response, err = httpClientWithoutCookie.Do(request)
 	}
 
 	if err != nil {
-		Log("Submit", GetLangText("Error-FetchResponse"), true, err.Error())
-		return -2, nil
+		return nil, failRequest(req, &RequestError { Type: GetNetworkErrorType(err), RequestID: currentRequestID, Err: err })
 	}
-	responseBody, err := ioutil.ReadAll(response.Body)
+
 	defer response.Body.Close()
 
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, (maxSize + 1)))
 	if err != nil {
-		Log("Submit", GetLangText("Error-ReadResponse"), true, err.Error())
-		return -3, nil
+		return nil, failRequest(req, &RequestError { Type: GetNetworkErrorType(err), RequestID: currentRequestID, Err: err })
 	}
 
-	if response.StatusCode == 401 {
-		Log("Submit", GetLangText("Error-NoAuth"), true)
-		return 401, nil
+	if int64(len(responseBody)) > maxSize {
+		return nil, &RequestError { Type: RequestError_TooLarge, RequestID: currentRequestID, StatusCode: response.StatusCode, MaxSize: maxSize }
 	}
 
-	if response.StatusCode == 403 {
-		if tryLogin {
-			Login()
-		}
-		Log("Submit", GetLangText("Error-Forbidden"), true)
-		return 403, nil
-	}
+	Log("Debug-Request", "#%d %d (%d Bytes)", false, currentRequestID, response.StatusCode, len(responseBody))
+
+	resp := &ResponseStruct { StatusCode: response.StatusCode, Header: response.Header, Body: responseBody }
 
-	if response.StatusCode == 409 {
-		// 尝试获取并设置 CSRF Token.
-		if currentClientType == "Transmission" {
-			transmissionCSRFToken := response.Header.Get("X-Transmission-Session-Id")
-			if transmissionCSRFToken != "" {
-				Tr_SetCSRFToken(transmissionCSRFToken)
-				return 409, nil
+	switch response.StatusCode {
+		case 200:
+			if req.WithAuth {
+				SetRequestResult(true)
 			}
-		}
+			return resp, nil
+		case 401:
+			return resp, failRequest(req, &RequestError { Type: RequestError_Auth, RequestID: currentRequestID, StatusCode: 401 })
+		case 403:
+			// 会话过期时重新登录, 并透明地重试一次原请求.
+			if req.TryLogin && allowRetry && Login() {
+				Log("Debug-Request", "#%d " + GetLangText("Debug-Request_Retry"), false, currentRequestID, req.URL)
+				return doRequest(req, false)
+			}
+			return resp, failRequest(req, &RequestError { Type: RequestError_Auth, RequestID: currentRequestID, StatusCode: 403 })
+		case 409:
+			// 尝试获取并设置 CSRF Token, 随后重试一次原请求.
+			if currentClientType == "Transmission" {
+				if transmissionCSRFToken := response.Header.Get("X-Transmission-Session-Id"); transmissionCSRFToken != "" {
+					Tr_SetCSRFToken(transmissionCSRFToken)
+					if req.WithAuth && allowRetry {
+						Log("Debug-Request", "#%d " + GetLangText("Debug-Request_Retry"), false, currentRequestID, req.URL)
+						return doRequest(req, false)
+					}
+					return resp, &RequestError { Type: RequestError_CSRF, RequestID: currentRequestID, StatusCode: 409 }
+				}
+			}
+			if req.TryLogin && allowRetry && Login() {
+				Log("Debug-Request", "#%d " + GetLangText("Debug-Request_Retry"), false, currentRequestID, req.URL)
+				return doRequest(req, false)
+			}
+			return resp, failRequest(req, &RequestError { Type: RequestError_CSRF, RequestID: currentRequestID, StatusCode: 409 })
+	}
 
-		if tryLogin {
-			Login()
-		}
+	requestErr := &RequestError { Type: RequestError_Status, RequestID: currentRequestID, StatusCode: response.StatusCode }
+	if response.StatusCode >= 500 {
+		return resp, failRequest(req, requestErr)
+	}
 
-		Log("Fetch", GetLangText("Error-Forbidden"), true)
-		return 409, nil
+	return resp, requestErr
+}
+func failRequest(req RequestStruct, requestErr *RequestError) *RequestError {
+	if req.WithAuth {
+		SetRequestResult(false)
 	}
 
-	if response.StatusCode == 404 {
-		Log("Submit", GetLangText("Error-NotFound"), true)
-		return 404, nil
+	return requestErr
+}
+func GetNetworkErrorType(err error) RequestErrorType {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return RequestError_Timeout
 	}
 
-	if response.StatusCode != 200 {
-		Log("Submit", GetLangText("Error-UnknownStatusCode"), true, response.StatusCode)
-		return response.StatusCode, nil
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return RequestError_Timeout
 	}
 
-	return response.StatusCode, responseBody
+	return RequestError_Network
 }
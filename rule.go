@@ -0,0 +1,571 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"regexp"
+	"errors"
+	"strings"
+	"strconv"
+	"unicode"
+)
+
+type RuleConfigStruct struct {
+	Name       string
+	Condition  string
+	Action     string
+	Priority   int
+	BanTime    uint32
+	BanIPCIDR  string
+	BanIP6CIDR string
+}
+type RuleStruct struct {
+	RuleConfigStruct
+	Node RuleNode
+}
+type RuleNode interface {
+	Eval(ruleContext map[string]interface{}) interface{}
+}
+type RuleNode_Literal struct {
+	Value interface{}
+}
+type RuleNode_Field struct {
+	Name string
+}
+type RuleNode_Not struct {
+	Node RuleNode
+}
+type RuleNode_Logic struct {
+	Op    string
+	Left  RuleNode
+	Right RuleNode
+}
+type RuleNode_Compare struct {
+	Op     string
+	Left   RuleNode
+	Right  RuleNode
+	Regexp *regexp.Regexp
+	Nets   []*net.IPNet
+}
+type ruleParserStruct struct {
+	tokens []string
+	pos    int
+}
+
+// 可在条件中使用的字段, 大小写不敏感.
+var ruleFieldList = []string { "client", "peerid", "ip", "port", "progress", "downloaded", "uploaded", "estimated", "dlspeed", "upspeed", "infohash", "torrentsize", "tracker", "private", "asn", "asorg", "country" }
+var ruleActionList = []string { "ban", "ban-ip-range", "log", "throttle", "allow" }
+var ruleSizeUnitMap = map[string]float64 { "kb": 1024, "mb": 1048576, "gb": 1073741824, "tb": 1099511627776 }
+var rulesCompiled []*RuleStruct
+
+func (n *RuleNode_Literal) Eval(ruleContext map[string]interface{}) interface{} {
+	return n.Value
+}
+func (n *RuleNode_Field) Eval(ruleContext map[string]interface{}) interface{} {
+	return ruleContext[n.Name]
+}
+func (n *RuleNode_Not) Eval(ruleContext map[string]interface{}) interface{} {
+	return !RuleValueToBool(n.Node.Eval(ruleContext))
+}
+func (n *RuleNode_Logic) Eval(ruleContext map[string]interface{}) interface{} {
+	left := RuleValueToBool(n.Left.Eval(ruleContext))
+	if n.Op == "&&" {
+		return (left && RuleValueToBool(n.Right.Eval(ruleContext)))
+	}
+	return (left || RuleValueToBool(n.Right.Eval(ruleContext)))
+}
+func (n *RuleNode_Compare) Eval(ruleContext map[string]interface{}) interface{} {
+	left := n.Left.Eval(ruleContext)
+
+	switch n.Op {
+		case "~", "!~":
+			matched := n.Regexp.MatchString(RuleValueToString(left))
+			return (matched == (n.Op == "~"))
+		case "in", "!in":
+			matched := false
+			if ip := net.ParseIP(RuleValueToString(left)); ip != nil {
+				for _, ipNet := range n.Nets {
+					if ipNet.Contains(ip) {
+						matched = true
+						break
+					}
+				}
+			} else if leftNumber, ok := left.(float64); ok {
+				matched = IsNumberInRangeList(leftNumber, RuleValueToString(n.Right.Eval(ruleContext)))
+			}
+			return (matched == (n.Op == "in"))
+	}
+
+	right := n.Right.Eval(ruleContext)
+	leftNumber, leftIsNumber := left.(float64)
+	rightNumber, rightIsNumber := right.(float64)
+
+	if leftIsNumber && rightIsNumber {
+		switch n.Op {
+			case "==":
+				return (leftNumber == rightNumber)
+			case "!=":
+				return (leftNumber != rightNumber)
+			case "<":
+				return (leftNumber < rightNumber)
+			case "<=":
+				return (leftNumber <= rightNumber)
+			case ">":
+				return (leftNumber > rightNumber)
+			case ">=":
+				return (leftNumber >= rightNumber)
+		}
+		return false
+	}
+
+	leftBool, leftIsBool := left.(bool)
+	rightBool, rightIsBool := right.(bool)
+	if leftIsBool && rightIsBool {
+		switch n.Op {
+			case "==":
+				return (leftBool == rightBool)
+			case "!=":
+				return (leftBool != rightBool)
+		}
+		return false
+	}
+
+	switch n.Op {
+		case "==":
+			return strings.EqualFold(RuleValueToString(left), RuleValueToString(right))
+		case "!=":
+			return !strings.EqualFold(RuleValueToString(left), RuleValueToString(right))
+	}
+
+	return false
+}
+func RuleValueToBool(value interface{}) bool {
+	switch v := value.(type) {
+		case bool:
+			return v
+		case float64:
+			return (v != 0)
+		case string:
+			return (v != "")
+	}
+
+	return false
+}
+func RuleValueToString(value interface{}) string {
+	switch v := value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(v)
+	}
+
+	return ""
+}
+func IsNumberInRangeList(number float64, rangeListStr string) bool {
+	// 格式: "6881-6889, 15000".
+	for _, rangeStr := range strings.Split(rangeListStr, ",") {
+		rangeArr := strings.SplitN(StrTrim(rangeStr), "-", 2)
+		rangeStart, err := strconv.ParseFloat(StrTrim(rangeArr[0]), 64)
+		if err != nil {
+			continue
+		}
+		rangeEnd := rangeStart
+		if len(rangeArr) == 2 {
+			if rangeEnd, err = strconv.ParseFloat(StrTrim(rangeArr[1]), 64); err != nil {
+				continue
+			}
+		}
+		if number >= rangeStart && number <= rangeEnd {
+			return true
+		}
+	}
+
+	return false
+}
+func TokenizeRule(condition string) ([]string, error) {
+	tokens := []string {}
+
+	for i := 0; i < len(condition); {
+		c := condition[i]
+
+		if unicode.IsSpace(rune(c)) {
+			i++
+			continue
+		}
+
+		if c == '"' || c == '\'' {
+			j := i + 1
+			for ; j < len(condition) && condition[j] != c; j++ {
+				if condition[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(condition) {
+				return nil, errors.New("unterminated string")
+			}
+			// 字符串以双引号标记保存, 仅处理引号本身的转义, 以便正则表达式原样保留.
+			tokens = append(tokens, "\"" + strings.Replace(condition[i + 1:j], "\\" + string(c), string(c), -1))
+			i = j + 1
+			continue
+		}
+
+		if i + 1 < len(condition) {
+			twoChar := condition[i:i + 2]
+			switch twoChar {
+				case "&&", "||", "==", "!=", "<=", ">=", "!~":
+					tokens = append(tokens, twoChar)
+					i += 2
+					continue
+			}
+		}
+
+		switch c {
+			case '(', ')', '<', '>', '~', '!':
+				tokens = append(tokens, string(c))
+				i++
+				continue
+		}
+
+		j := i
+		for ; j < len(condition) && (unicode.IsLetter(rune(condition[j])) || unicode.IsDigit(rune(condition[j])) || condition[j] == '.' || condition[j] == '_' || condition[j] == '%'); j++ {
+		}
+		if j == i {
+			return nil, errors.New("unexpected character: " + string(c))
+		}
+		tokens = append(tokens, condition[i:j])
+		i = j
+	}
+
+	return tokens, nil
+}
+func (p *ruleParserStruct) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+func (p *ruleParserStruct) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+func (p *ruleParserStruct) parseOr() (RuleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for token := strings.ToLower(p.peek()); token == "||" || token == "or"; token = strings.ToLower(p.peek()) {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &RuleNode_Logic { Op: "||", Left: left, Right: right }
+	}
+
+	return left, nil
+}
+func (p *ruleParserStruct) parseAnd() (RuleNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for token := strings.ToLower(p.peek()); token == "&&" || token == "and"; token = strings.ToLower(p.peek()) {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &RuleNode_Logic { Op: "&&", Left: left, Right: right }
+	}
+
+	return left, nil
+}
+func (p *ruleParserStruct) parseNot() (RuleNode, error) {
+	if token := strings.ToLower(p.peek()); token == "!" || token == "not" {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &RuleNode_Not { Node: node }, nil
+	}
+
+	return p.parseCompare()
+}
+func (p *ruleParserStruct) parseCompare() (RuleNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	op := strings.ToLower(p.peek())
+	if op == "not" && p.pos + 1 < len(p.tokens) && strings.ToLower(p.tokens[p.pos + 1]) == "in" {
+		p.next()
+		op = "!in"
+	}
+
+	switch op {
+		case "==", "!=", "<", "<=", ">", ">=", "~", "!~", "in", "!in":
+			p.next()
+		default:
+			return left, nil
+	}
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	compareNode := &RuleNode_Compare { Op: op, Left: left, Right: right }
+
+	switch op {
+		case "~", "!~":
+			literal, ok := right.(*RuleNode_Literal)
+			if !ok {
+				return nil, errors.New("regexp must be a string literal")
+			}
+			reg, err := regexp.Compile("(?i)" + RuleValueToString(literal.Value))
+			if err != nil {
+				return nil, err
+			}
+			compareNode.Regexp = reg
+		case "in", "!in":
+			literal, ok := right.(*RuleNode_Literal)
+			if !ok {
+				return nil, errors.New("in must be followed by a string literal")
+			}
+			if _, ok := literal.Value.(string); !ok {
+				return nil, errors.New("in must be followed by a string literal")
+			}
+			// 若右侧为 CIDR 列表则预先编译, 否则作为数字范围列表 (如端口) 处理.
+			for _, cidrStr := range strings.Split(RuleValueToString(literal.Value), ",") {
+				cidrStr = StrTrim(cidrStr)
+				if !strings.Contains(cidrStr, ".") && !strings.Contains(cidrStr, ":") {
+					continue
+				}
+				cidr := ParseIPCIDR(cidrStr)
+				if cidr == nil {
+					return nil, errors.New("bad CIDR: " + cidrStr)
+				}
+				compareNode.Nets = append(compareNode.Nets, cidr)
+			}
+	}
+
+	return compareNode, nil
+}
+func (p *ruleParserStruct) parsePrimary() (RuleNode, error) {
+	token := p.next()
+
+	if token == "" {
+		return nil, errors.New("unexpected end of condition")
+	}
+
+	if token == "(" {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return node, nil
+	}
+
+	if strings.HasPrefix(token, "\"") {
+		return &RuleNode_Literal { Value: token[1:] }, nil
+	}
+
+	lowerToken := strings.ToLower(token)
+
+	if lowerToken == "true" || lowerToken == "false" {
+		return &RuleNode_Literal { Value: (lowerToken == "true") }, nil
+	}
+
+	for _, field := range ruleFieldList {
+		if lowerToken == field {
+			return &RuleNode_Field { Name: field }, nil
+		}
+	}
+
+	if number, ok := ParseRuleNumber(lowerToken); ok {
+		return &RuleNode_Literal { Value: number }, nil
+	}
+
+	return nil, errors.New("unknown token: " + token)
+}
+func ParseRuleNumber(token string) (float64, bool) {
+	multiplier := float64(1)
+
+	if strings.HasSuffix(token, "%") {
+		multiplier = 0.01
+		token = strings.TrimSuffix(token, "%")
+	} else {
+		for unit, unitMultiplier := range ruleSizeUnitMap {
+			if strings.HasSuffix(token, unit) {
+				multiplier = unitMultiplier
+				token = strings.TrimSuffix(token, unit)
+				break
+			}
+		}
+	}
+
+	number, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return (number * multiplier), true
+}
+func ParseRuleCondition(condition string) (RuleNode, error) {
+	tokens, err := TokenizeRule(condition)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &ruleParserStruct { tokens: tokens }
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.pos < len(parser.tokens) {
+		return nil, errors.New("unexpected token: " + parser.peek())
+	}
+
+	return node, nil
+}
+func CompileRule(ruleConfig RuleConfigStruct) (*RuleStruct, error) {
+	ruleConfig.Action = strings.ToLower(ruleConfig.Action)
+	if ruleConfig.Action == "" {
+		ruleConfig.Action = "ban"
+	}
+
+	validAction := false
+	for _, action := range ruleActionList {
+		if ruleConfig.Action == action {
+			validAction = true
+			break
+		}
+	}
+	if !validAction {
+		return nil, errors.New("unknown action: " + ruleConfig.Action)
+	}
+
+	node, err := ParseRuleCondition(ruleConfig.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RuleStruct { RuleConfigStruct: ruleConfig, Node: node }, nil
+}
+func CompileRules() {
+	rulesCompiled = []*RuleStruct {}
+
+	for k, ruleConfig := range config.Rules {
+		if ruleConfig.Name == "" {
+			ruleConfig.Name = "#" + strconv.Itoa(k)
+		}
+
+		Log("Debug-LoadConfig_CompileRule", "%s: %s", false, ruleConfig.Name, ruleConfig.Condition)
+
+		rule, err := CompileRule(ruleConfig)
+		if err != nil {
+			Log("LoadConfig_CompileRule", GetLangText("Error-CompileRule"), true, ruleConfig.Name, err.Error())
+			continue
+		}
+
+		rulesCompiled = append(rulesCompiled, rule)
+	}
+
+	// 优先级高者先执行, 相同优先级保持配置顺序.
+	sort.SliceStable(rulesCompiled, func (i, j int) bool {
+		return rulesCompiled[i].Priority > rulesCompiled[j].Priority
+	})
+}
+func NewPeerRuleContext(peerIP string, peerPort int, peerID string, peerClient string, peerDlSpeed int64, peerUpSpeed int64, peerProgress float64, peerDownloaded int64, peerUploaded int64, peerEstimated bool, torrentInfoHash string, torrentTotalSize int64, torrentTracker string, torrentPrivate bool) map[string]interface{} {
+	// 未加载 GeoIP 数据库时, asn 为 0, asorg 及 country 为空.
+	geoIPInfo := GetGeoIPInfo(peerIP)
+	if geoIPInfo == nil {
+		geoIPInfo = &GeoIPInfoStruct {}
+	}
+
+	return map[string]interface{} {
+		"client":      peerClient,
+		"peerid":      peerID,
+		"ip":          peerIP,
+		"port":        float64(peerPort),
+		"progress":    peerProgress,
+		"downloaded":  float64(peerDownloaded),
+		"uploaded":    float64(peerUploaded),
+		"estimated":   peerEstimated,
+		"dlspeed":     float64(peerDlSpeed),
+		"upspeed":     float64(peerUpSpeed),
+		"infohash":    torrentInfoHash,
+		"torrentsize": float64(torrentTotalSize),
+		"tracker":     torrentTracker,
+		"private":     torrentPrivate,
+		"asn":         float64(geoIPInfo.ASN),
+		"asorg":       geoIPInfo.ASOrg,
+		"country":     geoIPInfo.Country,
+	}
+}
+func MatchRules(ruleContext map[string]interface{}, afterBuiltin bool) []*RuleStruct {
+	// 优先级不小于 0 的规则在内置检查前执行, 小于 0 的规则在内置检查后执行.
+	// log 及 throttle 不结束匹配, 以便后续的 ban 等规则仍可生效.
+	matchedRules := []*RuleStruct {}
+
+	for _, rule := range rulesCompiled {
+		if (rule.Priority < 0) != afterBuiltin {
+			continue
+		}
+		if RuleValueToBool(rule.Node.Eval(ruleContext)) {
+			matchedRules = append(matchedRules, rule)
+			if rule.Action != "log" && rule.Action != "throttle" {
+				break
+			}
+		}
+	}
+
+	return matchedRules
+}
+func ApplyRuleAction(rule *RuleStruct, peerIP string, peerPort int, torrentInfoHash string, torrentConfig *ConfigStruct) int {
+	banTime := int64(rule.BanTime)
+	if banTime <= 0 {
+		banTime = int64(torrentConfig.BanTime)
+	}
+
+	switch rule.Action {
+		case "ban":
+			AddBlockPeerWithBanTime(peerIP, peerPort, torrentInfoHash, banTime)
+			return 1
+		case "ban-ip-range":
+			cidr := ""
+			if IsIPv6(peerIP) {
+				cidr = rule.BanIP6CIDR
+				if cidr == "" {
+					cidr = torrentConfig.BanIP6CIDR
+				}
+			} else {
+				cidr = rule.BanIPCIDR
+				if cidr == "" {
+					cidr = torrentConfig.BanIPCIDR
+				}
+			}
+			if peerNet := ParseIPCIDR(peerIP + cidr); peerNet != nil && !dryRunMode {
+				blockCIDRMap[peerNet.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: peerNet, BanTime: banTime }
+			}
+			AddBlockPeerWithBanTime(peerIP, -1, torrentInfoHash, banTime)
+			return 3
+		case "throttle":
+			return ThrottlePeer(peerIP, peerPort, torrentInfoHash, rule.Name)
+		case "allow":
+			return -3
+	}
+
+	return 0
+}
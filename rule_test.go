@@ -0,0 +1,235 @@
+package main
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestTokenizeRule(t *testing.T) {
+	testCases := []struct {
+		condition string
+		tokens    []string
+	} {
+		{ `client ~ "Xunlei" && port != 6881`, []string { "client", "~", "\"Xunlei", "&&", "port", "!=", "6881" } },
+		{ `!(progress<=10%)||dlspeed>=1mb`, []string { "!", "(", "progress", "<=", "10%", ")", "||", "dlspeed", ">=", "1mb" } },
+		{ `peerid !~ '^-XL\'0'`, []string { "peerid", "!~", "\"^-XL'0" } },
+		{ `ip not in "10.0.0.0/8"`, []string { "ip", "not", "in", "\"10.0.0.0/8" } },
+	}
+
+	for _, testCase := range testCases {
+		tokens, err := TokenizeRule(testCase.condition)
+		if err != nil {
+			t.Errorf("TokenizeRule(%q): %v", testCase.condition, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, testCase.tokens) {
+			t.Errorf("TokenizeRule(%q) = %q, want %q", testCase.condition, tokens, testCase.tokens)
+		}
+	}
+}
+func TestParseRuleCondition(t *testing.T) {
+	ruleContext := map[string]interface{} {
+		"client":      "Xunlei 0019",
+		"peerid":      "-XL0019-",
+		"ip":          "10.1.2.3",
+		"port":        float64(6885),
+		"progress":    0.05,
+		"downloaded":  float64(0),
+		"uploaded":    float64(2097152),
+		"estimated":   false,
+		"dlspeed":     float64(0),
+		"upspeed":     float64(1048576),
+		"infohash":    "0123456789abcdef0123456789abcdef01234567",
+		"torrentsize": float64(1073741824),
+		"tracker":     "http://tracker.example.com/announce",
+		"private":     true,
+		"asn":         float64(0),
+		"asorg":       "",
+		"country":     "",
+	}
+
+	testCases := []struct {
+		condition string
+		result    bool
+	} {
+		// 比较运算符.
+		{ `port == 6885`, true },
+		{ `port != 6885`, false },
+		{ `port < 6885`, false },
+		{ `port <= 6885`, true },
+		{ `port > 6884`, true },
+		{ `port >= 6886`, false },
+		{ `client == "xunlei 0019"`, true },
+		{ `client != "Xunlei 0019"`, false },
+		{ `private == true`, true },
+		{ `estimated != false`, false },
+		{ `private`, true },
+		{ `estimated`, false },
+		// 单位及百分比.
+		{ `upspeed == 1mb`, true },
+		{ `uploaded == 2MB`, true },
+		{ `torrentsize == 1gb`, true },
+		{ `progress < 10%`, true },
+		{ `progress >= 5%`, true },
+		// 正则表达式.
+		{ `client ~ "^Xunlei"`, true },
+		{ `peerid ~ "^-XL\d+"`, true },
+		{ `client !~ "Xunlei"`, false },
+		{ `tracker ~ "example\.org"`, false },
+		// in 及 not in.
+		{ `ip in "10.0.0.0/8"`, true },
+		{ `ip in "192.168.0.0/16, 10.1.2.3"`, true },
+		{ `ip in "192.168.0.0/16"`, false },
+		{ `ip not in "192.168.0.0/16"`, true },
+		{ `port in "6881-6889"`, true },
+		{ `port in "6881-6884, 6886"`, false },
+		{ `port in "6000, 6885"`, true },
+		{ `port not in "6881-6889"`, false },
+		// 逻辑运算符.
+		{ `port == 6885 && client ~ "Xunlei"`, true },
+		{ `port == 6885 and client ~ "qBittorrent"`, false },
+		{ `port == 1 || client ~ "Xunlei"`, true },
+		{ `port == 1 or client ~ "qBittorrent"`, false },
+		{ `!private`, false },
+		{ `not private`, false },
+		{ `!!private`, true },
+		{ `!(port == 1)`, true },
+		// 优先级: ! 高于 &&, && 高于 ||.
+		{ `port == 6885 || port == 1 && private == false`, true },
+		{ `(port == 6885 || port == 1) && private == false`, false },
+		{ `port == 1 && private || client ~ "Xunlei"`, true },
+		{ `port == 1 && (private || client ~ "Xunlei")`, false },
+		{ `!private || port == 6885`, true },
+		{ `!(private || port == 6885)`, false },
+		{ `not port == 6885 or private`, true },
+	}
+
+	for _, testCase := range testCases {
+		node, err := ParseRuleCondition(testCase.condition)
+		if err != nil {
+			t.Errorf("ParseRuleCondition(%q): %v", testCase.condition, err)
+			continue
+		}
+		if result := RuleValueToBool(node.Eval(ruleContext)); result != testCase.result {
+			t.Errorf("%q = %t, want %t", testCase.condition, result, testCase.result)
+		}
+	}
+}
+func TestParseRuleConditionError(t *testing.T) {
+	testCases := []string {
+		``,
+		`client ~ "Xunlei`,
+		`client == 'Xunlei`,
+		`port == 6881 # comment`,
+		`port == 6881 ; private`,
+		`(port == 6881`,
+		`port == 6881)`,
+		`port == 6881 private`,
+		`port ==`,
+		`port == 6881 &&`,
+		`|| private`,
+		`unknown == 1`,
+		`port == 1xb`,
+		`client ~ client`,
+		`client ~ "("`,
+		`ip in 6881`,
+		`ip in "10.0.0.0/33"`,
+		`ip not "10.0.0.0/8"`,
+	}
+
+	for _, condition := range testCases {
+		if _, err := ParseRuleCondition(condition); err == nil {
+			t.Errorf("ParseRuleCondition(%q): expected error", condition)
+		}
+	}
+}
+func TestCompileRule(t *testing.T) {
+	rule, err := CompileRule(RuleConfigStruct { Name: "Test", Condition: `port == 6881` })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+	if rule.Action != "ban" {
+		t.Errorf("default action = %q, want %q", rule.Action, "ban")
+	}
+
+	rule, err = CompileRule(RuleConfigStruct { Name: "Test", Condition: `port == 6881`, Action: "Throttle" })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+	if rule.Action != "throttle" {
+		t.Errorf("action = %q, want %q", rule.Action, "throttle")
+	}
+
+	if _, err := CompileRule(RuleConfigStruct { Name: "Test", Condition: `port == 6881`, Action: "drop" }); err == nil {
+		t.Error("CompileRule with unknown action: expected error")
+	}
+	if _, err := CompileRule(RuleConfigStruct { Name: "Test", Condition: `port ==`, Action: "ban" }); err == nil {
+		t.Error("CompileRule with malformed condition: expected error")
+	}
+}
+func TestCheckPeerRulesThrottleThenBan(t *testing.T) {
+	// throttle 后仍应继续检查同一阶段的后续规则.
+	throttleRule, err := CompileRule(RuleConfigStruct { Name: "ThrottleXunlei", Condition: `client ~ "Xunlei"`, Action: "throttle", Priority: 10 })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+	banRule, err := CompileRule(RuleConfigStruct { Name: "BanXunlei", Condition: `client ~ "Xunlei" && uploaded > 20MB`, Action: "ban", Priority: 5 })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+
+	lastRulesCompiled := rulesCompiled
+	rulesCompiled = []*RuleStruct { throttleRule, banRule }
+	dryRunMode = true
+	dryRunReasons = []string {}
+	defer func() {
+		rulesCompiled = lastRulesCompiled
+		dryRunMode = false
+		dryRunReasons = nil
+	}()
+
+	if matchedRules := MatchRules(NewPeerRuleContext("203.0.113.7", 6881, "-XL0019-", "Xunlei 0019", 0, 0, 0.5, 0, (30 * 1024 * 1024), false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false), false); len(matchedRules) != 2 {
+		t.Fatalf("MatchRules = %d rules, want 2", len(matchedRules))
+	}
+
+	testCases := []struct {
+		uploaded   int64
+		ruleStatus int
+		ruleAction string
+	} {
+		{ (10 * 1024 * 1024), 0, "throttle" },
+		{ (30 * 1024 * 1024), 1, "ban" },
+	}
+
+	for _, testCase := range testCases {
+		ruleContext := NewPeerRuleContext("203.0.113.7", 6881, "-XL0019-", "Xunlei 0019", 0, 0, 0.5, 0, testCase.uploaded, false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false)
+		if ruleStatus, ruleAction := CheckPeerRules("203.0.113.7", 6881, "-XL0019-", "Xunlei 0019", ruleContext, false, "0123456789abcdef0123456789abcdef01234567", &config); ruleStatus != testCase.ruleStatus || ruleAction != testCase.ruleAction {
+			t.Errorf("CheckPeerRules (uploaded %d) = %d, %q, want %d, %q", testCase.uploaded, ruleStatus, ruleAction, testCase.ruleStatus, testCase.ruleAction)
+		}
+	}
+}
+func TestCheckPeerRulesLog(t *testing.T) {
+	// log 动作不应被视为封禁原因.
+	logRule, err := CompileRule(RuleConfigStruct { Name: "LogXunlei", Condition: `client ~ "Xunlei"`, Action: "log" })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+
+	lastRulesCompiled := rulesCompiled
+	rulesCompiled = []*RuleStruct { logRule }
+	dryRunMode = true
+	dryRunReasons = []string {}
+	defer func() {
+		rulesCompiled = lastRulesCompiled
+		dryRunMode = false
+		dryRunReasons = nil
+	}()
+
+	ruleContext := NewPeerRuleContext("203.0.113.7", 6881, "-XL0019-", "Xunlei 0019", 0, 0, 0.5, 0, 0, false, "0123456789abcdef0123456789abcdef01234567", 1073741824, "", false)
+	if ruleStatus, ruleAction := CheckPeerRules("203.0.113.7", 6881, "-XL0019-", "Xunlei 0019", ruleContext, false, "0123456789abcdef0123456789abcdef01234567", &config); ruleStatus != 0 || ruleAction != "" {
+		t.Errorf("CheckPeerRules = %d, %q, want 0, \"\"", ruleStatus, ruleAction)
+	}
+	if len(dryRunReasons) != 0 {
+		t.Errorf("dryRunReasons = %q, want none", dryRunReasons)
+	}
+}
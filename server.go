@@ -14,6 +14,10 @@ type httpServerHandler struct {
 }
 
 func (h *httpServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ProcessControlHTTP(w, r, false) {
+		return
+	}
+
 	if r.Method != "GET" {
 		w.WriteHeader(405)
 		w.Write([]byte("405: Method Not Allowed."))
@@ -27,9 +31,18 @@ func (h *httpServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(404)
 	w.Write([]byte("404: Not Found."))
 }
-func StartServer() {
-	if Server_httpListen != nil {
-		return
+func IsServerRequired() bool {
+	// 内置服务器用于 Transmission 的 ipfilter.dat, 启用 controlListen 时亦提供本机控制接口.
+	return (config.Listen != "" && (currentClientType == "Transmission" || config.ControlListen))
+}
+func StartServer() bool {
+	// Listener 在调用方同步创建, 仅 Serve 在 goroutine 中执行, 以免多次启动时争用端口.
+	if httpServer != nil {
+		return true
+	}
+
+	if config.ControlListen {
+		controlToken = LoadControlToken(true)
 	}
 
 	listenType := "tcp4"
@@ -40,20 +53,31 @@ func StartServer() {
 	httpListen, err := net.Listen(listenType, strings.SplitN(config.Listen, "/", 2)[0])
 	if err != nil {
 	    Log("StartServer", GetLangText("Error-StartServer_Listen"), true, err.Error())
-	    return
+	    return false
 	}
 
+	// 已关闭的 http.Server 无法再次使用, 因此每次启动时重新创建.
+	server := &http.Server {
+		ReadTimeout:  httpServerTimeout,
+		WriteTimeout: httpServerTimeout,
+		Handler:      &httpServerHandler {},
+	}
+	server.SetKeepAlivesEnabled(false)
+
+	httpServer = server
 	Server_httpListen = httpListen
 	Server_Status = true
 
-	httpServer.SetKeepAlivesEnabled(false)
-	if err := httpServer.Serve(Server_httpListen); err != http.ErrServerClosed {
-		Log("StartServer", GetLangText("Error-StartServer_Serve"), true, err.Error())
-		Server_Status = false
-	}
+	go func(server *http.Server, listener net.Listener) {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			Log("StartServer", GetLangText("Error-StartServer_Serve"), true, err.Error())
+		}
+	}(server, httpListen)
+
+	return true
 }
 func StopServer() {
-	if !Server_Status {
+	if httpServer == nil {
 		return
 	}
 
@@ -63,4 +87,15 @@ func StopServer() {
 
 	// 出于保险起见, 再次关闭似乎已被 httpServer 同时关闭的 Listener, 但无视错误.
 	Server_httpListen.Close()
+
+	httpServer = nil
+	Server_Status = false
+	Server_httpListen = nil
+}
+func RestartServer() {
+	StopServer()
+
+	if IsServerRequired() {
+		StartServer()
+	}
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRestartServer(t *testing.T) {
+	lastConfig := config
+	lastClientType := currentClientType
+	defer func() {
+		StopServer()
+		config = lastConfig
+		currentClientType = lastClientType
+	}()
+
+	currentClientType = "Transmission"
+	config.ControlListen = false
+	config.Listen = GetFreeListen(t)
+
+	// 返回时应已开始监听.
+	InitClient()
+	if !Server_Status || Server_httpListen == nil || Server_httpListen.Addr().String() != config.Listen {
+		t.Fatalf("InitClient: Server_Status = %t, want listening on %s", Server_Status, config.Listen)
+	}
+	firstServer := httpServer
+	firstListen := config.Listen
+
+	// 重复启动不应再次监听.
+	InitClient()
+	if httpServer != firstServer {
+		t.Fatal("InitClient: server started twice")
+	}
+
+	// 同时变更 Listen 并重新初始化客户端时, 应仅监听新地址.
+	config.Listen = GetFreeListen(t)
+	RestartServer()
+	InitClient()
+	if !Server_Status || httpServer == firstServer || Server_httpListen.Addr().String() != config.Listen {
+		t.Fatalf("RestartServer: Server_Status = %t, want listening on %s", Server_Status, config.Listen)
+	}
+	if testListen, err := net.Listen("tcp4", firstListen); err != nil {
+		t.Errorf("old listen %s was not released: %v", firstListen, err)
+	} else {
+		testListen.Close()
+	}
+
+	StopServer()
+	if Server_Status || httpServer != nil || Server_httpListen != nil {
+		t.Error("StopServer: server still running")
+	}
+}
@@ -0,0 +1,158 @@
+//go:build ignore
+
+// 生成测试使用的 MMDB 数据库: go run testdata/genmmdb.go.
+package main
+
+import (
+	"os"
+	"net"
+	"sort"
+	"bytes"
+)
+
+type networkStruct struct {
+	CIDR   string
+	Record map[string]interface{}
+}
+
+func EncodeControl(buf *bytes.Buffer, typeNum int, size int) {
+	// 类型 1-7 保存于控制字节, 其余类型使用扩展类型字节.
+	controlType := typeNum
+	if typeNum > 7 {
+		controlType = 0
+	}
+
+	var sizeBytes []byte
+	switch {
+		case size < 29:
+			buf.WriteByte(byte(controlType << 5 | size))
+		case size < 285:
+			buf.WriteByte(byte(controlType << 5 | 29))
+			sizeBytes = []byte { byte(size - 29) }
+		default:
+			buf.WriteByte(byte(controlType << 5 | 30))
+			sizeBytes = []byte { byte((size - 285) >> 8), byte(size - 285) }
+	}
+
+	if typeNum > 7 {
+		buf.WriteByte(byte(typeNum - 7))
+	}
+	buf.Write(sizeBytes)
+}
+func EncodeUint(buf *bytes.Buffer, typeNum int, value uint64) {
+	valueBytes := []byte {}
+	for ; value > 0; value >>= 8 {
+		valueBytes = append([]byte { byte(value) }, valueBytes...)
+	}
+	EncodeControl(buf, typeNum, len(valueBytes))
+	buf.Write(valueBytes)
+}
+func EncodeValue(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+		case string:
+			EncodeControl(buf, 2, len(v))
+			buf.WriteString(v)
+		case uint16:
+			EncodeUint(buf, 5, uint64(v))
+		case uint32:
+			EncodeUint(buf, 6, uint64(v))
+		case uint64:
+			EncodeUint(buf, 9, v)
+		case []interface{}:
+			EncodeControl(buf, 11, len(v))
+			for _, item := range v {
+				EncodeValue(buf, item)
+			}
+		case map[string]interface{}:
+			keys := []string {}
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			EncodeControl(buf, 7, len(keys))
+			for _, key := range keys {
+				EncodeValue(buf, key)
+				EncodeValue(buf, v[key])
+			}
+	}
+}
+func WriteDatabase(filename string, databaseType string, networks []networkStruct) {
+	// 仅生成 IPv4 数据库, 记录大小为 24 位.
+	type nodeStruct struct {
+		Child [2]int
+		Data  [2]int
+	}
+	nodes := []*nodeStruct { &nodeStruct { Child: [2]int { -1, -1 }, Data: [2]int { -1, -1 } } }
+	data := bytes.Buffer {}
+
+	for _, network := range networks {
+		_, cidr, err := net.ParseCIDR(network.CIDR)
+		if err != nil {
+			panic(err)
+		}
+		ip := cidr.IP.To4()
+		prefixLen, _ := cidr.Mask.Size()
+
+		dataOffset := data.Len()
+		EncodeValue(&data, network.Record)
+
+		node := nodes[0]
+		for i := 0; i < prefixLen; i++ {
+			bit := int(ip[i / 8] >> uint(7 - i % 8) & 1)
+			if i == prefixLen - 1 {
+				node.Data[bit] = dataOffset
+				break
+			}
+			if node.Child[bit] < 0 {
+				nodes = append(nodes, &nodeStruct { Child: [2]int { -1, -1 }, Data: [2]int { -1, -1 } })
+				node.Child[bit] = len(nodes) - 1
+			}
+			node = nodes[node.Child[bit]]
+		}
+	}
+
+	nodeCount := len(nodes)
+	tree := bytes.Buffer {}
+	for _, node := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := nodeCount
+			if node.Child[bit] >= 0 {
+				record = node.Child[bit]
+			} else if node.Data[bit] >= 0 {
+				record = nodeCount + 16 + node.Data[bit]
+			}
+			tree.Write([]byte { byte(record >> 16), byte(record >> 8), byte(record) })
+		}
+	}
+
+	output := bytes.Buffer {}
+	output.Write(tree.Bytes())
+	output.Write(make([]byte, 16))
+	output.Write(data.Bytes())
+	output.WriteString("\xAB\xCD\xEFMaxMind.com")
+	EncodeValue(&output, map[string]interface{} {
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{} { "en": "qBittorrent-ClientBlocker test database" },
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{} { "en" },
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})
+
+	if err := os.WriteFile(filename, output.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+}
+func main() {
+	WriteDatabase("testdata/GeoIP-ASN-Test.mmdb", "GeoLite2-ASN", []networkStruct {
+		networkStruct { CIDR: "203.0.113.0/24", Record: map[string]interface{} { "autonomous_system_number": uint32(64500), "autonomous_system_organization": "Example Offline Download" } },
+		networkStruct { CIDR: "198.51.100.0/24", Record: map[string]interface{} { "autonomous_system_number": uint32(64501), "autonomous_system_organization": "Example ISP" } },
+	})
+	WriteDatabase("testdata/GeoIP-Country-Test.mmdb", "GeoLite2-Country", []networkStruct {
+		networkStruct { CIDR: "203.0.113.0/24", Record: map[string]interface{} { "country": map[string]interface{} { "iso_code": "CN" } } },
+		networkStruct { CIDR: "198.51.100.0/25", Record: map[string]interface{} { "country": map[string]interface{} { "iso_code": "JP" } } },
+	})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"os"
+	"errors"
+	"strings"
+	"strconv"
+	"net/url"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+)
+
+type TestPeerStruct struct {
+	IP          string  `json:"ip"`
+	Port        int     `json:"port"`
+	PeerID      string  `json:"peerID"`
+	Client      string  `json:"client"`
+	DlSpeed     int64   `json:"dlSpeed"`
+	UpSpeed     int64   `json:"upSpeed"`
+	Progress    float64 `json:"progress"`
+	Downloaded  int64   `json:"downloaded"`
+	Uploaded    int64   `json:"uploaded"`
+	InfoHash    string  `json:"infoHash"`
+	TorrentSize int64   `json:"torrentSize"`
+	Tracker     string  `json:"tracker"`
+	Private     bool    `json:"private"`
+	Category    string  `json:"category"`
+	Tags        string  `json:"tags"`
+}
+type TestPeerResultStruct struct {
+	Peer          TestPeerStruct `json:"peer"`
+	TorrentStatus int            `json:"torrentStatus"`
+	Override      string         `json:"override,omitempty"`
+	Status        int            `json:"status"`
+	Verdict       string         `json:"verdict"`
+	Reasons       []string       `json:"reasons"`
+}
+
+// 试运行时不修改封禁列表, 不修改客户端设置, 并记录判定原因而非输出日志.
+var dryRunMode = false
+var dryRunReasons []string
+
+func RecordDryRunReason(module string, str string) bool {
+	if !dryRunMode || (!strings.Contains(module, "(Bad-") && !strings.Contains(module, "(Good-") && !strings.Contains(module, "(Blocked)")) {
+		return false
+	}
+
+	dryRunReasons = append(dryRunReasons, module + ": " + str)
+
+	return true
+}
+func SetTestPeerField(testPeer *TestPeerStruct, key string, value string) error {
+	var err error
+	value = StrTrim(value)
+
+	switch strings.ToLower(key) {
+		case "ip":
+			testPeer.IP = value
+		case "port":
+			testPeer.Port, err = strconv.Atoi(value)
+		case "peerid":
+			testPeer.PeerID = value
+		case "client":
+			testPeer.Client = value
+		case "dlspeed":
+			testPeer.DlSpeed, err = strconv.ParseInt(value, 10, 64)
+		case "upspeed":
+			testPeer.UpSpeed, err = strconv.ParseInt(value, 10, 64)
+		case "progress":
+			testPeer.Progress, err = strconv.ParseFloat(value, 64)
+		case "downloaded":
+			testPeer.Downloaded, err = strconv.ParseInt(value, 10, 64)
+		case "uploaded":
+			testPeer.Uploaded, err = strconv.ParseInt(value, 10, 64)
+		case "infohash":
+			testPeer.InfoHash = strings.ToLower(value)
+		case "torrentsize", "size":
+			testPeer.TorrentSize, err = strconv.ParseInt(value, 10, 64)
+		case "tracker":
+			testPeer.Tracker = value
+		case "private":
+			testPeer.Private, err = strconv.ParseBool(value)
+		case "category":
+			testPeer.Category = value
+		case "tags":
+			testPeer.Tags = value
+		default:
+			return errors.New("unknown peer field: " + key)
+	}
+
+	if err != nil {
+		return errors.New(key + ": " + err.Error())
+	}
+
+	return nil
+}
+func GetTestPeerVerdict(peerStatus int, ruleAction string, reasons []string) string {
+	switch peerStatus {
+		case 1:
+			return "ban"
+		case 2, 3:
+			if len(reasons) > 0 && strings.Contains(reasons[0], "(Blocked)") {
+				return "already-banned"
+			}
+			return "ban-ip"
+		case -1:
+			return "ignore-bad-peer"
+		case -2:
+			return "ignore-empty-peer"
+		case -3:
+			return "allow"
+	}
+
+	// 限速规则不影响返回值, 因此根据生效的规则动作区分.
+	if ruleAction == "throttle" {
+		return "throttle"
+	}
+
+	return "pass"
+}
+func TestPeer(testPeer TestPeerStruct) TestPeerResultStruct {
+	// 未指定速度时, 视为活跃 Peer, 否则 CheckPeer 会直接忽略.
+	if testPeer.DlSpeed <= 0 && testPeer.UpSpeed <= 0 {
+		testPeer.UpSpeed = 1
+	}
+	testPeer.IP = ProcessIP(testPeer.IP)
+
+	dryRunMode = true
+	dryRunReasons = []string {}
+	defer func() {
+		dryRunMode = false
+	}()
+
+	testResult := TestPeerResultStruct { Peer: testPeer, Reasons: dryRunReasons }
+
+	// 先按 CheckTorrent 的逻辑检查 Torrent. 未指定 Hash 时使用占位 Hash, 以免被视为空 Hash.
+	torrentInfoHash := testPeer.InfoHash
+	if torrentInfoHash == "" {
+		torrentInfoHash = strings.Repeat("0", 40)
+	}
+	torrentTrackers := []string {}
+	if testPeer.Tracker != "" {
+		torrentTrackers = append(torrentTrackers, testPeer.Tracker)
+	}
+
+	var torrentOverride *TorrentOverrideStruct
+	for _, compiledTorrentOverride := range torrentOverridesCompiled {
+		if IsTorrentOverrideMatch(compiledTorrentOverride, torrentInfoHash, testPeer.Category, SplitTorrentTags(testPeer.Tags), torrentTrackers) {
+			torrentOverride = compiledTorrentOverride
+			testResult.Override = torrentOverride.Name
+			break
+		}
+	}
+	torrentConfig := GetTorrentConfig(torrentOverride)
+
+	// 以 Peer 本身作为 Torrent Peers 传入, 防止 CheckTorrent 从客户端获取.
+	torrentPT := IsPTTorrent(&testPeer.Private, torrentTrackers)
+	testResult.TorrentStatus, _ = CheckTorrent(torrentInfoHash, torrentPT, 1, testPeer, torrentOverride, torrentConfig)
+	switch testResult.TorrentStatus {
+		case -5:
+			testResult.Verdict = "skip-torrent"
+			return testResult
+		case -4:
+			testResult.Verdict = "ignore-pt-torrent"
+			return testResult
+	}
+
+	// 规则中的 private 字段与实际运行时一致, 即同时考虑 PT Tracker.
+	var ruleAction string
+	testResult.Status, _, ruleAction = CheckPeer(testPeer.IP, testPeer.Port, testPeer.PeerID, testPeer.Client, testPeer.DlSpeed, testPeer.UpSpeed, testPeer.Progress, testPeer.Downloaded, testPeer.Uploaded, false, torrentInfoHash, testPeer.TorrentSize, testPeer.Tracker, torrentPT, torrentConfig)
+	testResult.Verdict = GetTestPeerVerdict(testResult.Status, ruleAction, dryRunReasons)
+	testResult.Reasons = dryRunReasons
+
+	return testResult
+}
+func LoadTestPeerFile(filename string) ([]TestPeerStruct, error) {
+	testPeerFile, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	testPeers := []TestPeerStruct {}
+
+	// CSV 首行为字段名, 字段名与 --test-peer 一致.
+	if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+		records, err := csv.NewReader(strings.NewReader(string(testPeerFile))).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) <= 0 {
+			return testPeers, nil
+		}
+
+		for _, record := range records[1:] {
+			testPeer := TestPeerStruct {}
+			for k, value := range record {
+				if k >= len(records[0]) {
+					break
+				}
+				if err := SetTestPeerField(&testPeer, records[0][k], value); err != nil {
+					return nil, err
+				}
+			}
+			testPeers = append(testPeers, testPeer)
+		}
+
+		return testPeers, nil
+	}
+
+	// JSON 可为 Peer 数组或单个 Peer.
+	testPeerJSON := []byte(strings.TrimSpace(string(testPeerFile)))
+	if strings.HasPrefix(string(testPeerJSON), "{") {
+		testPeerJSON = append(append([]byte("["), testPeerJSON...), ']')
+	}
+	if err := json.Unmarshal(testPeerJSON, &testPeers); err != nil {
+		return nil, err
+	}
+
+	return testPeers, nil
+}
+func LoadOfflineConfig(modifyConfig func(*ConfigStruct)) bool {
+	// 加载配置及列表, 但不连接客户端, 亦不写入日志文件.
+	LoadConfig()
+	LoadAdditionalConfig()
+	offlineConfig, err := BuildConfig()
+	if err != nil {
+		Log("LoadOfflineConfig", GetLangText("Error-ParseConfig"), false, err.Error())
+		return false
+	}
+	offlineConfig.LogToFile = false
+	if modifyConfig != nil {
+		modifyConfig(&offlineConfig)
+	}
+	config = offlineConfig
+	InitConfig()
+	SetIPBlockListFromURL()
+	SetBlockListFromURL()
+	LoadAllGeoIPDatabase()
+
+	return true
+}
+func RunOfflineTest(testPeerQuery string, testPeerFilename string) int {
+	// 判定结果输出至标准输出, 日志输出至标准错误.
+	logOutput = os.Stderr
+
+	testPeers := []TestPeerStruct {}
+
+	if testPeerQuery != "" {
+		testPeerValues, err := url.ParseQuery(testPeerQuery)
+		if err != nil {
+			Log("RunOfflineTest", GetLangText("Error-LoadTestPeer"), false, testPeerQuery, err.Error())
+			return 1
+		}
+
+		testPeer := TestPeerStruct {}
+		for key := range testPeerValues {
+			if err := SetTestPeerField(&testPeer, key, testPeerValues.Get(key)); err != nil {
+				Log("RunOfflineTest", GetLangText("Error-LoadTestPeer"), false, testPeerQuery, err.Error())
+				return 1
+			}
+		}
+		testPeers = append(testPeers, testPeer)
+	}
+
+	if testPeerFilename != "" {
+		fileTestPeers, err := LoadTestPeerFile(testPeerFilename)
+		if err != nil {
+			Log("RunOfflineTest", GetLangText("Error-LoadTestPeer"), false, testPeerFilename, err.Error())
+			return 1
+		}
+		testPeers = append(testPeers, fileTestPeers...)
+	}
+
+	if !LoadOfflineConfig(nil) {
+		return 1
+	}
+
+	for _, testPeer := range testPeers {
+		testResult := TestPeer(testPeer)
+		os.Stdout.WriteString(testResult.Peer.IP + ":" + strconv.Itoa(testResult.Peer.Port) + "\t" + testResult.Verdict + "\t" + strings.Join(testResult.Reasons, " | ") + "\n")
+	}
+
+	return 0
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTestPeerRuleVerdict(t *testing.T) {
+	throttleRule, err := CompileRule(RuleConfigStruct { Name: "ThrottleXunlei", Condition: `client ~ "^Xunlei"`, Action: "throttle" })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+	privateRule, err := CompileRule(RuleConfigStruct { Name: "BanPrivate", Condition: `private && client ~ "^Transmission"`, Action: "ban" })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+	// 未指定 Hash 时, CheckPeer 应与 CheckTorrent 使用相同的占位 Hash.
+	infoHashRule, err := CompileRule(RuleConfigStruct { Name: "BanPlaceholderHash", Condition: `infoHash == "0000000000000000000000000000000000000000" && client ~ "^qBittorrent"`, Action: "ban" })
+	if err != nil {
+		t.Fatalf("CompileRule: %v", err)
+	}
+
+	lastRulesCompiled := rulesCompiled
+	lastIgnorePTTorrent := config.IgnorePTTorrent
+	lastPTTrackerList := config.PTTrackerList
+	rulesCompiled = []*RuleStruct { throttleRule, privateRule, infoHashRule }
+	config.IgnorePTTorrent = false
+	config.PTTrackerList = []string { "pt.example.com" }
+	defer func() {
+		rulesCompiled = lastRulesCompiled
+		config.IgnorePTTorrent = lastIgnorePTTorrent
+		config.PTTrackerList = lastPTTrackerList
+	}()
+
+	testCases := []struct {
+		testPeer TestPeerStruct
+		verdict  string
+	} {
+		{ TestPeerStruct { IP: "203.0.113.7", Port: 6881, Client: "Xunlei 0019", Progress: 0.5 }, "throttle" },
+		{ TestPeerStruct { IP: "203.0.113.8", Port: 6881, Client: "Transmission 4.0.5", Progress: 0.5 }, "pass" },
+		{ TestPeerStruct { IP: "203.0.113.8", Port: 6881, Client: "Transmission 4.0.5", Progress: 0.5, Private: true }, "ban" },
+		// private 字段同时考虑 PT Tracker, 与实际运行时一致.
+		{ TestPeerStruct { IP: "203.0.113.8", Port: 6881, Client: "Transmission 4.0.5", Progress: 0.5, Tracker: "https://pt.example.com/announce" }, "ban" },
+		{ TestPeerStruct { IP: "203.0.113.9", Port: 6881, Client: "qBittorrent 4.6.0", Progress: 0.5 }, "ban" },
+		{ TestPeerStruct { IP: "203.0.113.9", Port: 6881, Client: "qBittorrent 4.6.0", Progress: 0.5, InfoHash: "0123456789abcdef0123456789abcdef01234567" }, "pass" },
+	}
+
+	for _, testCase := range testCases {
+		if testResult := TestPeer(testCase.testPeer); testResult.Verdict != testCase.verdict {
+			t.Errorf("TestPeer(%+v) = %q (Reasons: %q), want %q", testCase.testPeer, testResult.Verdict, testResult.Reasons, testCase.verdict)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"os"
+	"encoding/json"
+)
+
+type ThrottlePeerStruct struct {
+	Count    uint32
+	LastSeen int64
+}
+type ThrottleTorrentStruct struct {
+	Timestamp   int64
+	UploadLimit int64
+	Limited     bool
+	LastSeen    int64
+	Peers       map[string]ThrottlePeerStruct
+}
+
+var throttleTorrentMap = make(map[string]ThrottleTorrentStruct)
+
+func ThrottlePeer(peerIP string, peerPort int, torrentInfoHash string, ruleName string) int {
+	if dryRunMode {
+		return 0
+	}
+
+	throttleTorrent, exist := throttleTorrentMap[torrentInfoHash]
+	if !exist {
+		// 记录原有的限速, 以便可疑 Peer 离开后恢复.
+		uploadLimit, ok := GetTorrentUploadLimit(torrentInfoHash)
+		if !ok {
+			Log("ThrottlePeer", GetLangText("Failed-ThrottleTorrent"), true, torrentInfoHash, ruleName)
+			return 0
+		}
+
+		// 若原有限速已低于限速值, 则无需修改.
+		throttleUploadLimit := (int64(config.ThrottleUploadLimit) * 1024)
+		limited := (uploadLimit <= 0 || uploadLimit > throttleUploadLimit)
+		if limited && !SetTorrentUploadLimit(torrentInfoHash, throttleUploadLimit) {
+			Log("ThrottlePeer", GetLangText("Failed-ThrottleTorrent"), true, torrentInfoHash, ruleName)
+			return 0
+		}
+
+		throttleTorrent = ThrottleTorrentStruct { Timestamp: currentTimestamp, UploadLimit: uploadLimit, Limited: limited, LastSeen: currentTimestamp, Peers: make(map[string]ThrottlePeerStruct) }
+		throttleTorrentMap[torrentInfoHash] = throttleTorrent
+		SaveThrottleState()
+		Log("ThrottlePeer", GetLangText("Success-ThrottleTorrent"), true, torrentInfoHash, config.ThrottleUploadLimit, ruleName)
+	}
+
+	throttlePeer := throttleTorrent.Peers[peerIP]
+	throttlePeer.Count++
+	throttlePeer.LastSeen = currentTimestamp
+	throttleTorrent.Peers[peerIP] = throttlePeer
+	throttleTorrentMap[torrentInfoHash] = throttleTorrent
+
+	// 限速后仍持续触发规则, 则升级为封禁.
+	if config.ThrottleEscalateCount > 0 && throttlePeer.Count > config.ThrottleEscalateCount {
+		Log("ThrottlePeer_AddBlockPeer (Bad-Throttle_Escalate)", "%s:%d (TorrentInfoHash: %s, Rule: %s, ThrottleCount: %d)", true, peerIP, peerPort, torrentInfoHash, ruleName, throttlePeer.Count)
+		AddBlockPeer(peerIP, peerPort, torrentInfoHash)
+		return 1
+	}
+
+	return 0
+}
+func SeenThrottlePeer(peerIP string, torrentInfoHash string) {
+	if throttleTorrent, exist := throttleTorrentMap[torrentInfoHash]; exist {
+		if throttlePeer, exist := throttleTorrent.Peers[peerIP]; exist {
+			throttlePeer.LastSeen = currentTimestamp
+			throttleTorrent.Peers[peerIP] = throttlePeer
+		}
+	}
+}
+func SeenThrottleTorrent(torrentInfoHash string) {
+	if throttleTorrent, exist := throttleTorrentMap[torrentInfoHash]; exist {
+		throttleTorrent.LastSeen = currentTimestamp
+		throttleTorrentMap[torrentInfoHash] = throttleTorrent
+	}
+}
+func CheckAllThrottle() {
+	// 超过 3 个周期未出现或已被封禁的 Peer 视为已离开, 所有可疑 Peer 均离开后恢复原有限速.
+	expireTimestamp := (currentTimestamp - int64(config.Interval) * 3)
+	throttleChanged := false
+
+	for torrentInfoHash, throttleTorrent := range throttleTorrentMap {
+		for peerIP, throttlePeer := range throttleTorrent.Peers {
+			if _, blocked := blockPeerMap[peerIP]; blocked || throttlePeer.LastSeen < expireTimestamp {
+				delete(throttleTorrent.Peers, peerIP)
+			}
+		}
+
+		if len(throttleTorrent.Peers) > 0 {
+			continue
+		}
+
+		if throttleTorrent.Limited && !SetTorrentUploadLimit(torrentInfoHash, throttleTorrent.UploadLimit) {
+			// 本周期未出现的 Torrent 可能已被删除, 直接移除, 以免每个周期重试.
+			if throttleTorrent.LastSeen < currentTimestamp {
+				delete(throttleTorrentMap, torrentInfoHash)
+				throttleChanged = true
+				Log("CheckAllThrottle", GetLangText("Abandon-UnthrottleTorrent"), true, torrentInfoHash)
+				continue
+			}
+			Log("CheckAllThrottle", GetLangText("Failed-UnthrottleTorrent"), true, torrentInfoHash)
+			continue
+		}
+
+		delete(throttleTorrentMap, torrentInfoHash)
+		throttleChanged = true
+		Log("CheckAllThrottle", GetLangText("Success-UnthrottleTorrent"), true, torrentInfoHash)
+	}
+
+	if throttleChanged {
+		SaveThrottleState()
+	}
+}
+func RestoreAllThrottle() {
+	// 程序退出时恢复所有 Torrent 的原有限速, 失败的 Torrent 保留于状态文件中, 下次启动时重试.
+	for torrentInfoHash, throttleTorrent := range throttleTorrentMap {
+		if throttleTorrent.Limited && !SetTorrentUploadLimit(torrentInfoHash, throttleTorrent.UploadLimit) {
+			Log("RestoreAllThrottle", GetLangText("Failed-RestoreThrottleTorrent"), true, torrentInfoHash)
+			continue
+		}
+
+		delete(throttleTorrentMap, torrentInfoHash)
+		Log("RestoreAllThrottle", GetLangText("Success-RestoreThrottleTorrent"), true, torrentInfoHash)
+	}
+
+	SaveThrottleState()
+}
+func SaveThrottleState() {
+	if config.ThrottleStateFile == "" || dryRunMode || replayMode {
+		return
+	}
+
+	// 仅记录已修改限速的 Torrent 及其原有限速.
+	throttleStateMap := make(map[string]int64)
+	for torrentInfoHash, throttleTorrent := range throttleTorrentMap {
+		if throttleTorrent.Limited {
+			throttleStateMap[torrentInfoHash] = throttleTorrent.UploadLimit
+		}
+	}
+
+	if len(throttleStateMap) <= 0 {
+		if err := os.Remove(config.ThrottleStateFile); err != nil && !os.IsNotExist(err) {
+			Log("SaveThrottleState", GetLangText("Error-SaveThrottleState"), true, config.ThrottleStateFile, err.Error())
+		}
+		return
+	}
+
+	throttleStateJSON, err := json.Marshal(throttleStateMap)
+	if err != nil {
+		Log("SaveThrottleState", GetLangText("Error-GenJSON"), true, err.Error())
+		return
+	}
+
+	if err := os.WriteFile(config.ThrottleStateFile, throttleStateJSON, 0600); err != nil {
+		Log("SaveThrottleState", GetLangText("Error-SaveThrottleState"), true, config.ThrottleStateFile, err.Error())
+	}
+}
+func LoadThrottleState() {
+	if config.ThrottleStateFile == "" {
+		return
+	}
+
+	throttleStateJSON, err := os.ReadFile(config.ThrottleStateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("LoadThrottleState", GetLangText("Error-LoadThrottleState"), true, config.ThrottleStateFile, err.Error())
+		}
+		return
+	}
+
+	throttleStateMap := make(map[string]int64)
+	if err := json.Unmarshal(throttleStateJSON, &throttleStateMap); err != nil {
+		Log("LoadThrottleState", GetLangText("Error-LoadThrottleState"), true, config.ThrottleStateFile, err.Error())
+		return
+	}
+
+	// 上次退出时未能恢复的限速, 作为无可疑 Peer 的条目加入, 由 CheckAllThrottle 恢复.
+	for torrentInfoHash, uploadLimit := range throttleStateMap {
+		if _, exist := throttleTorrentMap[torrentInfoHash]; !exist {
+			throttleTorrentMap[torrentInfoHash] = ThrottleTorrentStruct { Timestamp: currentTimestamp, UploadLimit: uploadLimit, Limited: true, LastSeen: currentTimestamp, Peers: make(map[string]ThrottlePeerStruct) }
+		}
+	}
+
+	Log("LoadThrottleState", GetLangText("Success-LoadThrottleState"), true, len(throttleStateMap))
+}
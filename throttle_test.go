@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"path/filepath"
+)
+
+func TestThrottleState(t *testing.T) {
+	lastThrottleStateFile := config.ThrottleStateFile
+	lastThrottleTorrentMap := throttleTorrentMap
+	lastClientType := currentClientType
+	lastTimestamp := currentTimestamp
+	config.ThrottleStateFile = filepath.Join(t.TempDir(), "throttle.json")
+	currentClientType = ""
+	currentTimestamp = 1700000000
+	defer func() {
+		config.ThrottleStateFile = lastThrottleStateFile
+		throttleTorrentMap = lastThrottleTorrentMap
+		currentClientType = lastClientType
+		currentTimestamp = lastTimestamp
+	}()
+
+	// 仅记录已修改限速的 Torrent.
+	throttleTorrentMap = map[string]ThrottleTorrentStruct {
+		"0123456789abcdef0123456789abcdef01234567": ThrottleTorrentStruct { Timestamp: currentTimestamp, UploadLimit: 1048576, Limited: true, LastSeen: currentTimestamp, Peers: make(map[string]ThrottlePeerStruct) },
+		"89abcdef0123456789abcdef0123456789abcdef": ThrottleTorrentStruct { Timestamp: currentTimestamp, UploadLimit: 262144, Limited: false, LastSeen: currentTimestamp, Peers: make(map[string]ThrottlePeerStruct) },
+	}
+	SaveThrottleState()
+
+	throttleTorrentMap = make(map[string]ThrottleTorrentStruct)
+	LoadThrottleState()
+	if len(throttleTorrentMap) != 1 {
+		t.Fatalf("LoadThrottleState: %d torrents, want 1", len(throttleTorrentMap))
+	}
+	throttleTorrent := throttleTorrentMap["0123456789abcdef0123456789abcdef01234567"]
+	if !throttleTorrent.Limited || throttleTorrent.UploadLimit != 1048576 {
+		t.Fatalf("LoadThrottleState: %+v", throttleTorrent)
+	}
+
+	// 无法恢复时, 仍存在的 Torrent 保留以便重试, 已不存在的 Torrent 则移除.
+	currentTimestamp++
+	SeenThrottleTorrent("0123456789abcdef0123456789abcdef01234567")
+	CheckAllThrottle()
+	if _, exist := throttleTorrentMap["0123456789abcdef0123456789abcdef01234567"]; !exist {
+		t.Fatal("CheckAllThrottle: seen torrent was dropped")
+	}
+
+	currentTimestamp++
+	CheckAllThrottle()
+	if _, exist := throttleTorrentMap["0123456789abcdef0123456789abcdef01234567"]; exist {
+		t.Fatal("CheckAllThrottle: vanished torrent was not dropped")
+	}
+	if _, err := os.Stat(config.ThrottleStateFile); !os.IsNotExist(err) {
+		t.Errorf("throttle state file was not removed: %v", err)
+	}
+}
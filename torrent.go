@@ -4,6 +4,7 @@ import (
 	"net"
 	"time"
 	"strings"
+	"net/url"
 )
 
 type TorrentInfoStruct struct {
@@ -15,13 +16,17 @@ var torrentMap = make(map[string]TorrentInfoStruct)
 var lastTorrentMap = make(map[string]TorrentInfoStruct)
 var lastTorrentCleanTimestamp int64 = 0
 
-func AddTorrentInfo(torrentInfoHash string, torrentTotalSize int64, cidr *net.IPNet, peerIP string, peerPort int, peerProgress float64, peerUploaded int64) {
-	if !((config.IPUploadedCheck && config.IPUpCheckPerTorrentRatio > 0) || config.BanByRelativeProgressUploaded) {
+func IsTorrentMapEnabled(torrentConfig *ConfigStruct) bool {
+	return ((torrentConfig.IPUploadedCheck && torrentConfig.IPUpCheckPerTorrentRatio > 0) || torrentConfig.BanByRelativeProgressUploaded || torrentConfig.BanByStagnantProgress)
+}
+func AddTorrentInfo(torrentInfoHash string, torrentTotalSize int64, cidr *net.IPNet, peerIP string, peerPort int, peerProgress float64, peerUploaded int64, peerEstimated bool, torrentConfig *ConfigStruct) {
+	if !IsTorrentMapEnabled(torrentConfig) {
 		return
 	}
 
 	var peers map[string]PeerInfoStruct
 	var peerPortMap map[int]bool
+	newPeerInfo := PeerInfoStruct { FirstSeen: currentTimestamp }
 	if torrentInfo, exist := torrentMap[torrentInfoHash]; !exist {
 		peers = make(map[string]PeerInfoStruct)
 		peerPortMap = make(map[int]bool)
@@ -32,6 +37,11 @@ func AddTorrentInfo(torrentInfoHash string, torrentTotalSize int64, cidr *net.IP
 		} else {
 			peerPortMap = peerInfo.Port
 
+			// 保留停滞检测的跨周期状态.
+			newPeerInfo.FirstSeen = peerInfo.FirstSeen
+			newPeerInfo.StagnantCycles = peerInfo.StagnantCycles
+			newPeerInfo.StagnantUploaded = peerInfo.StagnantUploaded
+
 			// 防止 Peer 在周期内以重新连接的方式清空实际上传量.
 			if peerInfo.Uploaded > peerUploaded {
 				peerUploaded += peerInfo.Uploaded
@@ -40,11 +50,16 @@ func AddTorrentInfo(torrentInfoHash string, torrentTotalSize int64, cidr *net.IP
 	}
 	peerPortMap[peerPort] = true
 
-	peers[peerIP] = PeerInfoStruct { Net: cidr, Port: peerPortMap, Progress: peerProgress, Uploaded: peerUploaded }
+	newPeerInfo.Net = cidr
+	newPeerInfo.Port = peerPortMap
+	newPeerInfo.Progress = peerProgress
+	newPeerInfo.Uploaded = peerUploaded
+	newPeerInfo.Estimated = peerEstimated
+	peers[peerIP] = newPeerInfo
 	torrentMap[torrentInfoHash] = TorrentInfoStruct { Size: torrentTotalSize, Peers: peers }
 }
-func IsProgressNotMatchUploaded(torrentTotalSize int64, clientProgress float64, clientUploaded int64) bool {
-	if config.BanByProgressUploaded && torrentTotalSize > 0 && clientProgress >= 0 && clientUploaded > 0 {
+func IsProgressNotMatchUploaded(torrentTotalSize int64, clientProgress float64, clientUploaded int64, torrentConfig *ConfigStruct) bool {
+	if torrentConfig.BanByProgressUploaded && torrentTotalSize > 0 && clientProgress >= 0 && clientUploaded > 0 {
 		/*
 		条件 1. 若客户端对 Peer 上传已大于等于 Torrnet 大小的 2%;
 		条件 2. 但 Peer 报告进度乘以下载量再乘以一定防误判倍率, 却比客户端上传量还小;
@@ -59,59 +74,115 @@ func IsProgressNotMatchUploaded(torrentTotalSize int64, clientProgress float64,
 		满足此条件;
 		则该 Peer 将被封禁, 由于其报告进度为 1%, 算入 config.BanByPUAntiErrorRatio 滞后防误判倍率后为 5% (5GB), 但客户端实际却已上传 6GB.
 		*/
-		startUploaded := (float64(torrentTotalSize) * (config.BanByPUStartPrecent / 100))
+		startUploaded := (float64(torrentTotalSize) * (torrentConfig.BanByPUStartPrecent / 100))
 		peerReportDownloaded := (float64(torrentTotalSize) * clientProgress)
-		if (clientUploaded / 1024 / 1024) >= int64(config.BanByPUStartMB) && float64(clientUploaded) >= startUploaded && (peerReportDownloaded * config.BanByPUAntiErrorRatio) < float64(clientUploaded) {
+		if (clientUploaded / 1024 / 1024) >= int64(torrentConfig.BanByPUStartMB) && float64(clientUploaded) >= startUploaded && (peerReportDownloaded * torrentConfig.BanByPUAntiErrorRatio) < float64(clientUploaded) {
 			return true
 		}
 	}
 	return false
 }
-func IsProgressNotMatchUploaded_Relative(torrentTotalSize int64, peerInfo PeerInfoStruct, lastPeerInfo PeerInfoStruct) int64 {
+func IsProgressNotMatchUploaded_Relative(torrentTotalSize int64, peerInfo PeerInfoStruct, lastPeerInfo PeerInfoStruct, torrentConfig *ConfigStruct) int64 {
 	// 若客户端对 Peer 上传已大于 0, 且相对上传量大于起始上传量, 则继续判断.
 	var relativeUploaded int64 = (peerInfo.Uploaded - lastPeerInfo.Uploaded)
 
-	if torrentTotalSize > 0 && peerInfo.Uploaded > 0 && (float64(relativeUploaded) / 1024 / 1024) > float64(config.BanByRelativePUStartMB) {
+	if torrentTotalSize > 0 && peerInfo.Uploaded > 0 && (float64(relativeUploaded) / 1024 / 1024) > float64(torrentConfig.BanByRelativePUStartMB) {
 		relativeUploadedPrecent := (1 - (float64(lastPeerInfo.Uploaded) / float64(peerInfo.Uploaded)))
 		// 若相对上传百分比大于起始百分比, 则继续判断.
-		if relativeUploadedPrecent > (config.BanByRelativePUStartPrecent / 100) {
+		if relativeUploadedPrecent > (torrentConfig.BanByRelativePUStartPrecent / 100) {
 			// 若相对上传百分比大于 Peer 报告进度乘以一定防误判倍率, 则认为 Peer 是有问题的.
 			var peerReportProgress float64 = 0
 			if peerInfo.Progress > 0 {
 				peerReportProgress = (1 - (lastPeerInfo.Progress / peerInfo.Progress))
 			}
-			if relativeUploadedPrecent > (peerReportProgress * config.BanByRelativePUAntiErrorRatio) {
+			if relativeUploadedPrecent > (peerReportProgress * torrentConfig.BanByRelativePUAntiErrorRatio) {
 				return relativeUploaded
 			}
 		}
 	}
 	return 0
 }
+func IsProgressStagnant(peerInfo PeerInfoStruct, lastPeerInfo PeerInfoStruct) (bool, int64) {
+	// 进度未变化 (或倒退) 但客户端仍在向 Peer 上传, 则认为本周期进度停滞.
+	relativeUploaded := (peerInfo.Uploaded - lastPeerInfo.Uploaded)
+	if relativeUploaded <= 0 {
+		return false, 0
+	}
+
+	if peerInfo.Progress > lastPeerInfo.Progress {
+		return false, relativeUploaded
+	}
+
+	return true, relativeUploaded
+}
 func CheckAllTorrent(torrentMap map[string]TorrentInfoStruct, lastTorrentMap map[string]TorrentInfoStruct) (int, int) {
-	if ((config.IPUploadedCheck && config.IPUpCheckPerTorrentRatio > 0) || config.BanByRelativeProgressUploaded) && len(lastTorrentMap) > 0 && currentTimestamp > (lastTorrentCleanTimestamp + int64(config.TorrentMapCleanInterval)) {
+	if (IsTorrentMapEnabled(&config) || IsTorrentOverrideMapEnabled()) && currentTimestamp > (lastTorrentCleanTimestamp + int64(config.TorrentMapCleanInterval)) {
 		blockCount := 0
 		ipBlockCount := 0
 
+		// lastTorrentMap 仅在比较后更新, 因此首个周期须先记录, 否则依赖上个周期的检查永远不会执行.
+		if len(lastTorrentMap) <= 0 {
+			lastTorrentCleanTimestamp = currentTimestamp
+			DeepCopyTorrentMap(torrentMap, lastTorrentMap)
+			return 0, 0
+		}
+
 		for torrentInfoHash, torrentInfo := range torrentMap {
+			torrentOverride := torrentOverrideMap[torrentInfoHash]
+			if torrentOverride != nil && torrentOverride.Skip {
+				continue
+			}
+
+			torrentConfig := GetTorrentConfig(torrentOverride)
 			for peerIP, peerInfo := range torrentInfo.Peers {
 				if IsBlockedPeer(peerIP, -1, true) {
 					continue
 				}
 
-				if config.IPUploadedCheck && config.IPUpCheckPerTorrentRatio > 0 {
-					if float64(peerInfo.Uploaded) > (float64(torrentInfo.Size) * peerInfo.Progress * config.IPUpCheckPerTorrentRatio) {
+				if torrentConfig.IPUploadedCheck && torrentConfig.IPUpCheckPerTorrentRatio > 0 {
+					if float64(peerInfo.Uploaded) > (float64(torrentInfo.Size) * peerInfo.Progress * torrentConfig.IPUpCheckPerTorrentRatio) {
 						if peerInfo.Net != nil {
 							blockCIDRMap[peerInfo.Net.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: peerInfo.Net }
 						}
 						ipBlockCount++
-						AddBlockPeer(peerIP, -1, torrentInfoHash)
+						AddBlockPeerWithBanTime(peerIP, -1, torrentInfoHash, int64(torrentConfig.BanTime))
 						continue
 					}
 				}
 
-				if config.BanByRelativeProgressUploaded {
+				if torrentConfig.BanByRelativeProgressUploaded {
+					if lastPeerInfo, exist := lastTorrentMap[torrentInfoHash].Peers[peerIP]; exist {
+						if uploadDuring := IsProgressNotMatchUploaded_Relative(torrentInfo.Size, peerInfo, lastPeerInfo, torrentConfig); uploadDuring > 0 {
+							for port := range peerInfo.Port {
+								if IsBlockedPeer(peerIP, port, true) {
+									continue
+								}
+								if peerInfo.Net != nil {
+									blockCIDRMap[peerInfo.Net.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: peerInfo.Net }
+								}
+								blockCount++
+								Log("CheckAllTorrent_AddBlockPeer (Bad-Relative_Progress_Uploaded)", "%s:%d (UploadDuring: %.2f MB, Estimated: %t)", true, peerIP, port, (float64(uploadDuring) / 1024 / 1024), peerInfo.Estimated)
+								AddBlockPeerWithBanTime(peerIP, port, torrentInfoHash, int64(torrentConfig.BanTime))
+							}
+							continue
+						}
+					}
+				}
+
+				if torrentConfig.BanByStagnantProgress {
 					if lastPeerInfo, exist := lastTorrentMap[torrentInfoHash].Peers[peerIP]; exist {
-						if uploadDuring := IsProgressNotMatchUploaded_Relative(torrentInfo.Size, peerInfo, lastPeerInfo); uploadDuring > 0 {
+						stagnant, relativeUploaded := IsProgressStagnant(peerInfo, lastPeerInfo)
+						if stagnant {
+							peerInfo.StagnantCycles++
+							peerInfo.StagnantUploaded += relativeUploaded
+						} else if relativeUploaded > 0 {
+							peerInfo.StagnantCycles = 0
+							peerInfo.StagnantUploaded = 0
+						}
+						torrentInfo.Peers[peerIP] = peerInfo
+
+						// 新连接的 Peer 在宽限期内不会被屏蔽.
+						if peerInfo.StagnantCycles >= torrentConfig.StagnantProgressCycles && (peerInfo.StagnantUploaded / 1024 / 1024) >= int64(torrentConfig.StagnantProgressUploadedMB) && currentTimestamp >= (peerInfo.FirstSeen + int64(torrentConfig.StagnantProgressGracePeriod)) {
 							for port := range peerInfo.Port {
 								if IsBlockedPeer(peerIP, port, true) {
 									continue
@@ -120,8 +191,8 @@ func CheckAllTorrent(torrentMap map[string]TorrentInfoStruct, lastTorrentMap map
 									blockCIDRMap[peerInfo.Net.String()] = BlockCIDRInfoStruct { Timestamp: currentTimestamp, Net: peerInfo.Net }
 								}
 								blockCount++
-								Log("CheckAllTorrent_AddBlockPeer (Bad-Relative_Progress_Uploaded)", "%s:%d (UploadDuring: %.2f MB)", true, peerIP, port, uploadDuring)
-								AddBlockPeer(peerIP, port, torrentInfoHash)
+								Log("CheckAllTorrent_AddBlockPeer (Bad-Stagnant_Progress)", "%s:%d (TorrentInfoHash: %s, Progress: %.2f%%, LastProgress: %.2f%%, StagnantCycles: %d, StagnantUploaded: %.2f MB, Estimated: %t)", true, peerIP, port, torrentInfoHash, (peerInfo.Progress * 100), (lastPeerInfo.Progress * 100), peerInfo.StagnantCycles, (float64(peerInfo.StagnantUploaded) / 1024 / 1024), peerInfo.Estimated)
+								AddBlockPeerWithBanTime(peerIP, port, torrentInfoHash, int64(torrentConfig.BanTime))
 							}
 							continue
 						}
@@ -138,22 +209,74 @@ func CheckAllTorrent(torrentMap map[string]TorrentInfoStruct, lastTorrentMap map
 
 	return 0, 0
 }
-func CheckTorrent(torrentInfoHash string, torrentTracker string, torrentLeecherCount int64, torrentPeers interface{}) (int, interface{}) {
-	if torrentInfoHash == "" {
-		return -1, nil
+func GetTrackerHost(torrentTracker string) string {
+	trackerURL, err := url.Parse(torrentTracker)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(trackerURL.Hostname())
+}
+func IsTrackerHostInList(trackerHost string, trackerHostList []string) bool {
+	if trackerHost == "" {
+		return false
+	}
+
+	// 同时匹配子域名, 如 example.org 可匹配 tracker.example.org.
+	for _, listTrackerHost := range trackerHostList {
+		listTrackerHost = strings.ToLower(StrTrim(listTrackerHost))
+		if listTrackerHost != "" && (trackerHost == listTrackerHost || strings.HasSuffix(trackerHost, "." + listTrackerHost)) {
+			return true
+		}
+	}
+
+	return false
+}
+func IsPTTorrent(torrentPrivate *bool, torrentTrackers []string) bool {
+	// 1. 客户端报告的私有标志最为可靠;
+	if torrentPrivate != nil && *torrentPrivate {
+		return true
+	}
+
+	// 2. 任一 Tracker 位于 PTTrackerList 中, 则视为 PT;
+	for _, torrentTracker := range torrentTrackers {
+		if IsTrackerHostInList(GetTrackerHost(torrentTracker), config.PTTrackerList) {
+			return true
+		}
+	}
+
+	// 3. 客户端明确报告非私有, 则不再猜测;
+	if torrentPrivate != nil {
+		return false
 	}
 
-	if config.IgnorePTTorrent && torrentTracker != "" {
-		if torrentTracker == "Private" {
-			return -4, nil
+	// 4. 客户端不提供私有标志时, 根据所有不在 PublicTrackerList 中的 Tracker URL 猜测.
+	for _, torrentTracker := range torrentTrackers {
+		if IsTrackerHostInList(GetTrackerHost(torrentTracker), config.PublicTrackerList) {
+			continue
 		}
 
 		lowerTorrentTracker := strings.ToLower(torrentTracker)
 		if strings.Contains(lowerTorrentTracker, "?passkey=") || strings.Contains(lowerTorrentTracker, "?authkey=") || strings.Contains(lowerTorrentTracker, "?secure=") || randomStrRegexp.MatchString(lowerTorrentTracker) {
-			return -4, nil
+			return true
 		}
 	}
 
+	return false
+}
+func CheckTorrent(torrentInfoHash string, torrentPT bool, torrentLeecherCount int64, torrentPeers interface{}, torrentOverride *TorrentOverrideStruct, torrentConfig *ConfigStruct) (int, interface{}) {
+	if torrentInfoHash == "" {
+		return -1, nil
+	}
+
+	if torrentOverride != nil && torrentOverride.Skip {
+		return -5, nil
+	}
+
+	if torrentConfig.IgnorePTTorrent && torrentPT {
+		return -4, nil
+	}
+
 	if torrentLeecherCount <= 0 {
 		return -2, nil
 	}
@@ -169,11 +292,23 @@ func CheckTorrent(torrentInfoHash string, torrentTracker string, torrentLeecherC
 
 	return 0, torrentPeers
 }
-func ProcessTorrent(torrentInfoHash string, torrentTracker string, torrentLeecherCount int64, torrentTotalSize int64, torrentPeers interface{}, emptyHashCount *int, noLeechersCount *int, badTorrentInfoCount *int, ptTorrentCount *int, blockCount *int, ipBlockCount *int, badPeersCount *int, emptyPeersCount *int) {
+func ProcessTorrent(torrentInfoHash string, torrentTracker string, torrentTrackers []string, torrentPrivate *bool, torrentCategory string, torrentTags []string, torrentLeecherCount int64, torrentTotalSize int64, torrentPeers interface{}, emptyHashCount *int, noLeechersCount *int, badTorrentInfoCount *int, ptTorrentCount *int, skipTorrentCount *int, blockCount *int, ipBlockCount *int, badPeersCount *int, emptyPeersCount *int) {
 	torrentInfoHash = strings.ToLower(torrentInfoHash)
-	torrentStatus, torrentPeersStruct := CheckTorrent(torrentInfoHash, torrentTracker, torrentLeecherCount, torrentPeers)
+	SeenThrottleTorrent(torrentInfoHash)
+
+	torrentOverride := MatchTorrentOverride(torrentInfoHash, torrentCategory, torrentTags, torrentTrackers)
+	torrentConfig := GetTorrentConfig(torrentOverride)
+
+	// 规则中的 tracker 字段使用客户端当前工作的 Tracker, 完整的 Tracker 列表仅用于覆盖配置匹配及 PT 判断.
+	torrentPT := IsPTTorrent(torrentPrivate, torrentTrackers)
+
+	torrentStatus, torrentPeersStruct := CheckTorrent(torrentInfoHash, torrentPT, torrentLeecherCount, torrentPeers, torrentOverride, torrentConfig)
 	if config.Debug_CheckTorrent {
-		Log("Debug-CheckTorrent", "%s (Status: %d)", false, torrentInfoHash, torrentStatus)
+		if torrentOverride != nil {
+			Log("Debug-CheckTorrent", "%s (Status: %d, Override: %s)", false, torrentInfoHash, torrentStatus, torrentOverride.Name)
+		} else {
+			Log("Debug-CheckTorrent", "%s (Status: %d)", false, torrentInfoHash, torrentStatus)
+		}
 	}
 
 	skipSleep := false
@@ -190,18 +325,22 @@ func ProcessTorrent(torrentInfoHash string, torrentTracker string, torrentLeeche
 		case -4:
 			skipSleep = true
 			*ptTorrentCount++
+		case -5:
+			skipSleep = true
+			*skipTorrentCount++
 		case 0:
 			switch currentClientType {
 				case "qBittorrent":
 					torrentPeers := torrentPeersStruct.(*qB_TorrentPeersStruct).Peers
 					for _, peer := range torrentPeers {
-						ProcessPeer(peer.IP, peer.Port, peer.PeerID, peer.Client, peer.DlSpeed, peer.UpSpeed, peer.Progress, peer.Downloaded, peer.Uploaded, torrentInfoHash, torrentTotalSize, blockCount, ipBlockCount, badPeersCount, emptyPeersCount)
+						ProcessPeer(peer.IP, peer.Port, peer.PeerID, peer.Client, peer.DlSpeed, peer.UpSpeed, peer.Progress, peer.Downloaded, peer.Uploaded, false, torrentInfoHash, torrentTotalSize, torrentTracker, torrentPT, torrentConfig, blockCount, ipBlockCount, badPeersCount, emptyPeersCount)
 					}
 				case "Transmission":
 					torrentPeers := torrentPeersStruct.([]Tr_PeerStruct)
 					for _, peer := range torrentPeers {
-						// Transmission 目前似乎并不提供 Peer 的 PeerID 及 Uploaded, 因此使用无效值取代.
-						ProcessPeer(peer.IP, peer.Port, "", peer.Client, peer.DlSpeed, peer.UpSpeed, peer.Progress, -1, -1, torrentInfoHash, torrentTotalSize, blockCount, ipBlockCount, badPeersCount, emptyPeersCount)
+						// Transmission 目前似乎并不提供 Peer 的 PeerID, 因此使用无效值取代; Downloaded 及 Uploaded 则使用估算值.
+						peerDownloaded, peerUploaded := Tr_EstimatePeer(torrentInfoHash, torrentTotalSize, peer)
+						ProcessPeer(peer.IP, peer.Port, "", peer.Client, peer.DlSpeed, peer.UpSpeed, peer.Progress, peerDownloaded, peerUploaded, true, torrentInfoHash, torrentTotalSize, torrentTracker, torrentPT, torrentConfig, blockCount, ipBlockCount, badPeersCount, emptyPeersCount)
 					}
 			}
 	}
@@ -4,6 +4,7 @@ import (
 	"net"
 	"time"
 	"strings"
+	"strconv"
 	"os/exec"
 	"encoding/json"
 )
@@ -23,6 +24,83 @@ func DeepCopyTorrentMap(src map[string]TorrentInfoStruct, dest map[string]Torren
 		}
 	}
 }
+func DeepCopyConfig(src ConfigStruct) ConfigStruct {
+	dest := ConfigStruct {}
+	if jsonStr, err := json.Marshal(src); err == nil {
+		json.Unmarshal(jsonStr, &dest)
+	}
+
+	return dest
+}
+func UnescapeINIKey(key string) string {
+	// Qt QSettings 会以 %XX/%UXXXX 转义键名中的特殊字符.
+	if !strings.Contains(key, "%") {
+		return key
+	}
+
+	unescapedKey := ""
+	for i := 0; i < len(key); i++ {
+		if key[i] == '%' {
+			if i + 5 < len(key) && key[i + 1] == 'U' {
+				if r, err := strconv.ParseUint(key[i + 2:i + 6], 16, 32); err == nil {
+					unescapedKey += string(rune(r))
+					i += 5
+					continue
+				}
+			} else if i + 2 < len(key) {
+				if r, err := strconv.ParseUint(key[i + 1:i + 3], 16, 8); err == nil {
+					unescapedKey += string(rune(r))
+					i += 2
+					continue
+				}
+			}
+		}
+		unescapedKey += string(key[i])
+	}
+
+	return unescapedKey
+}
+func UnquoteINIValue(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
+		if unquotedValue, err := strconv.Unquote(value); err == nil {
+			return unquotedValue
+		}
+		return value[1:len(value) - 1]
+	}
+
+	return value
+}
+func ParseINI(content []byte) map[string]map[string]string {
+	// 键名统一转为小写, 以便不区分大小写地读取.
+	iniMap := make(map[string]map[string]string)
+	section := ""
+	iniMap[section] = make(map[string]string)
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = StrTrim(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(UnescapeINIKey(StrTrim(line[1:len(line) - 1])))
+			if _, exist := iniMap[section]; !exist {
+				iniMap[section] = make(map[string]string)
+			}
+			continue
+		}
+
+		lineArr := strings.SplitN(line, "=", 2)
+		if len(lineArr) < 2 {
+			continue
+		}
+
+		key := strings.ToLower(UnescapeINIKey(StrTrim(lineArr[0])))
+		iniMap[section][key] = UnquoteINIValue(StrTrim(lineArr[1]))
+	}
+
+	return iniMap
+}
 func IsUnix(path string) bool {
 	return !strings.Contains(path, "\\")
 }
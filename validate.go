@@ -0,0 +1,554 @@
+package main
+
+import (
+	"os"
+	"net"
+	"regexp"
+	"reflect"
+	"strings"
+	"strconv"
+	"net/url"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+)
+
+type ConfigIssueStruct struct {
+	Position string
+	Key      string
+	Error    bool
+	Message  string
+}
+
+var defaultConfig = config
+var configKeyPositionMap = make(map[string]string)
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func GetLineByOffset(content []byte, offset int64) int {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+
+	return (strings.Count(string(content[:offset]), "\n") + 1)
+}
+func FormatConfigPosition(filename string, content []byte, offset int64) string {
+	// 非 JSON 格式的配置文件已被转换为 JSON, 偏移量与原文件不对应, 因此仅显示文件名, 行号由 GetConfigKeyLines 补充.
+	if content == nil {
+		return filename
+	}
+
+	return filename + ":" + strconv.Itoa(GetLineByOffset(content, offset))
+}
+func GetConfigKeyPosition(key string) string {
+	if position, exist := configKeyPositionMap[strings.ToLower(key)]; exist {
+		return position
+	}
+
+	return ""
+}
+func GetConfigFieldType(schema reflect.Type, key string) (reflect.Type, bool) {
+	// 与 encoding/json 一致, 键名大小写不敏感.
+	for k := 0; k < schema.NumField(); k++ {
+		field := schema.Field(k)
+		if strings.EqualFold(field.Name, key) {
+			// Torrent 覆盖配置中的 config 为 ConfigStruct 的子集.
+			if schema == reflect.TypeOf(TorrentOverrideConfigStruct {}) && field.Name == "Config" {
+				return reflect.TypeOf(ConfigStruct {}), true
+			}
+			return field.Type, true
+		}
+	}
+
+	return nil, false
+}
+func GetJSONKindName(schema reflect.Type) string {
+	switch schema.Kind() {
+		case reflect.Bool:
+			return "bool"
+		case reflect.String:
+			return "string"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return "int"
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return "uint"
+		case reflect.Float32, reflect.Float64:
+			return "number"
+		case reflect.Slice:
+			return "array"
+		case reflect.Struct, reflect.Map:
+			return "object"
+	}
+
+	return schema.String()
+}
+func CheckConfigValueType(schema reflect.Type, token json.Token) string {
+	// 返回空字符串表示类型匹配.
+	if schema == nil || schema.Kind() == reflect.Interface || token == nil || reflect.PtrTo(schema).Implements(jsonUnmarshalerType) {
+		return ""
+	}
+
+	expectedKind := GetJSONKindName(schema)
+
+	switch v := token.(type) {
+		case bool:
+			if schema.Kind() == reflect.Bool {
+				return ""
+			}
+		case string:
+			if schema.Kind() == reflect.String {
+				return ""
+			}
+		case json.Number:
+			switch expectedKind {
+				case "number":
+					return ""
+				case "int":
+					if _, err := v.Int64(); err == nil {
+						return ""
+					}
+				case "uint":
+					if _, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+						return ""
+					}
+			}
+		case json.Delim:
+			if (v == '[' && expectedKind == "array") || (v == '{' && expectedKind == "object") {
+				return ""
+			}
+	}
+
+	return "expected " + expectedKind
+}
+func WalkConfigJSON(decoder *json.Decoder, content []byte, filename string, keyPath string, schema reflect.Type, issues *[]ConfigIssueStruct) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if message := CheckConfigValueType(schema, token); message != "" {
+		*issues = append(*issues, ConfigIssueStruct { Position: GetConfigKeyPosition(keyPath), Key: keyPath, Error: true, Message: message })
+		schema = nil
+	}
+
+	delim, isDelim := token.(json.Delim)
+	if !isDelim {
+		return nil
+	}
+
+	if delim == '{' {
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			key, _ := keyToken.(string)
+			childKeyPath := strings.ToLower(key)
+			if keyPath != "" {
+				childKeyPath = keyPath + "." + childKeyPath
+			}
+			configKeyPositionMap[childKeyPath] = FormatConfigPosition(filename, content, decoder.InputOffset())
+
+			var childSchema reflect.Type
+			if schema != nil {
+				switch schema.Kind() {
+					case reflect.Struct:
+						fieldType, exist := GetConfigFieldType(schema, key)
+						if !exist {
+							*issues = append(*issues, ConfigIssueStruct { Position: GetConfigKeyPosition(childKeyPath), Key: childKeyPath, Error: true, Message: "unknown key" })
+						}
+						childSchema = fieldType
+					case reflect.Map:
+						childSchema = schema.Elem()
+				}
+			}
+
+			if err := WalkConfigJSON(decoder, content, filename, childKeyPath, childSchema, issues); err != nil {
+				return err
+			}
+		}
+	} else {
+		var childSchema reflect.Type
+		if schema != nil && schema.Kind() == reflect.Slice {
+			childSchema = schema.Elem()
+		}
+
+		for k := 0; decoder.More(); k++ {
+			childKeyPath := keyPath + "[" + strconv.Itoa(k) + "]"
+			configKeyPositionMap[childKeyPath] = FormatConfigPosition(filename, content, decoder.InputOffset())
+
+			if err := WalkConfigJSON(decoder, content, filename, childKeyPath, childSchema, issues); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 读取结束符.
+	_, err = decoder.Token()
+	return err
+}
+func GetYAMLKeyLines(node *yaml.Node, keyPath string, keyLines map[string]int) {
+	switch node.Kind {
+		case yaml.DocumentNode:
+			for _, childNode := range node.Content {
+				GetYAMLKeyLines(childNode, keyPath, keyLines)
+			}
+		case yaml.MappingNode:
+			for k := 0; (k + 1) < len(node.Content); k += 2 {
+				childKeyPath := strings.ToLower(node.Content[k].Value)
+				if keyPath != "" {
+					childKeyPath = keyPath + "." + childKeyPath
+				}
+				keyLines[childKeyPath] = node.Content[k].Line
+				GetYAMLKeyLines(node.Content[k + 1], childKeyPath, keyLines)
+			}
+		case yaml.SequenceNode:
+			for k, childNode := range node.Content {
+				childKeyPath := keyPath + "[" + strconv.Itoa(k) + "]"
+				keyLines[childKeyPath] = childNode.Line
+				GetYAMLKeyLines(childNode, childKeyPath, keyLines)
+			}
+	}
+}
+func GetTOMLKeyLines(configFile []byte) map[string]int {
+	// BurntSushi/toml 不提供键的位置, 因此逐行识别表头 ([table]/[[array]]) 及 key = value.
+	// 点分键, 嵌套于数组表中的子表及多行值中的键无法识别, 此时仅显示文件名.
+	keyLines := make(map[string]int)
+	arrayCount := make(map[string]int)
+	tablePath := ""
+
+	for k, line := range strings.Split(string(configFile), "\n") {
+		line = StrTrim(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[[") {
+			if end := strings.Index(line, "]]"); end > 2 {
+				name := strings.ToLower(StrTrim(line[2:end]))
+				if _, exist := keyLines[name]; !exist {
+					keyLines[name] = (k + 1)
+				}
+				tablePath = name + "[" + strconv.Itoa(arrayCount[name]) + "]"
+				arrayCount[name]++
+				keyLines[tablePath] = (k + 1)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if end := strings.Index(line, "]"); end > 1 {
+				tablePath = strings.ToLower(StrTrim(line[1:end]))
+				keyLines[tablePath] = (k + 1)
+			}
+			continue
+		}
+
+		if end := strings.Index(line, "="); end > 0 {
+			keyPath := strings.ToLower(strings.Trim(StrTrim(line[:end]), "\"'"))
+			if tablePath != "" {
+				keyPath = tablePath + "." + keyPath
+			}
+			if _, exist := keyLines[keyPath]; !exist {
+				keyLines[keyPath] = (k + 1)
+			}
+		}
+	}
+
+	return keyLines
+}
+func GetConfigKeyLines(filename string, configFile []byte) map[string]int {
+	// 非 JSON 格式的配置文件转换为 JSON 后, 偏移量与原文件不对应, 因此从原文件中获取各键所在的行.
+	switch GetConfigFormat(filename) {
+		case "yaml":
+			var node yaml.Node
+			keyLines := make(map[string]int)
+			if err := yaml.Unmarshal(configFile, &node); err == nil {
+				GetYAMLKeyLines(&node, "", keyLines)
+			}
+			return keyLines
+		case "toml":
+			return GetTOMLKeyLines(configFile)
+	}
+
+	return nil
+}
+func ValidateConfigKeys(filename string, configFile []byte) []ConfigIssueStruct {
+	// jsonc.ToJSON 以空白替换注释及多余的逗号, 因此偏移量与行号均与原文件一致.
+	issues := []ConfigIssueStruct {}
+
+	content, err := ConvertConfigToJSON(filename, configFile)
+	if err != nil {
+		return append(issues, ConfigIssueStruct { Position: filename, Error: true, Message: err.Error() })
+	}
+
+	lineContent := content
+	if GetConfigFormat(filename) != "json" {
+		lineContent = nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.UseNumber()
+
+	if err := WalkConfigJSON(decoder, lineContent, filename, "", reflect.TypeOf(ConfigStruct {}), &issues); err != nil {
+		position := filename
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			position = FormatConfigPosition(filename, lineContent, syntaxErr.Offset)
+		}
+		issues = append(issues, ConfigIssueStruct { Position: position, Error: true, Message: err.Error() })
+	}
+
+	if keyLines := GetConfigKeyLines(filename, configFile); keyLines != nil {
+		for keyPath, line := range keyLines {
+			if _, exist := configKeyPositionMap[keyPath]; exist {
+				configKeyPositionMap[keyPath] = filename + ":" + strconv.Itoa(line)
+			}
+		}
+		for k, issue := range issues {
+			if issue.Key != "" {
+				issues[k].Position = GetConfigKeyPosition(issue.Key)
+			}
+		}
+	}
+
+	return issues
+}
+func IsValidCIDRPrefix(cidr string, maxOnes int) bool {
+	if !strings.HasPrefix(cidr, "/") {
+		return false
+	}
+
+	ones, err := strconv.Atoi(cidr[1:])
+	return (err == nil && ones >= 0 && ones <= maxOnes)
+}
+func ValidateConfigValues(cfg ConfigStruct, withCompile bool) []ConfigIssueStruct {
+	issues := []ConfigIssueStruct {}
+	addIssue := func (key string, isError bool, message string) {
+		issues = append(issues, ConfigIssueStruct { Position: GetConfigKeyPosition(key), Key: key, Error: isError, Message: message })
+	}
+
+	// 范围检查.
+	if cfg.Interval < 1 {
+		addIssue("interval", true, "must be at least 1")
+	}
+	if cfg.Timeout < 1 {
+		addIssue("timeout", true, "must be at least 1")
+	}
+	if cfg.StagnantProgressCycles < 1 {
+		addIssue("stagnantprogresscycles", false, "less than 1, 1 will be used")
+	}
+	if cfg.ThrottleUploadLimit < 1 {
+		addIssue("throttleuploadlimit", false, "less than 1, 1 will be used")
+	}
+	if cfg.BanTime == 0 {
+		addIssue("bantime", false, "bans expire immediately")
+	}
+	if cfg.ClientType != "" && cfg.ClientType != "qBittorrent" && cfg.ClientType != "Transmission" {
+		addIssue("clienttype", true, "must be empty, qBittorrent or Transmission")
+	}
+
+	for key, cidr := range map[string]string { "banipcidr": cfg.BanIPCIDR, "correlationipcidr": cfg.CorrelationIPCIDR } {
+		if !IsValidCIDRPrefix(cidr, 32) {
+			addIssue(key, true, "must be /0 to /32")
+		}
+	}
+	for key, cidr := range map[string]string { "banip6cidr": cfg.BanIP6CIDR, "correlationip6cidr": cfg.CorrelationIP6CIDR } {
+		if !IsValidCIDRPrefix(cidr, 128) {
+			addIssue(key, true, "must be /0 to /128")
+		}
+	}
+	for k, cidr := range cfg.CIDRPromotionLadder {
+		if !IsValidCIDRPrefix(cidr, 32) {
+			addIssue("cidrpromotionladder[" + strconv.Itoa(k) + "]", true, "must be /0 to /32")
+		}
+	}
+	for k, cidr := range cfg.CIDRPromotion6Ladder {
+		if !IsValidCIDRPrefix(cidr, 128) {
+			addIssue("cidrpromotion6ladder[" + strconv.Itoa(k) + "]", true, "must be /0 to /128")
+		}
+	}
+
+	for key, precent := range map[string]float64 { "banbypustartprecent": cfg.BanByPUStartPrecent, "banbyrelativepustartprecent": cfg.BanByRelativePUStartPrecent, "correlationmaxprogress": cfg.CorrelationMaxProgress } {
+		if precent < 0 || precent > 100 {
+			addIssue(key, true, "must be 0 to 100")
+		}
+	}
+
+	for key, urlStr := range map[string]string { "clienturl": cfg.ClientURL, "blocklisturl": cfg.BlockListURL, "ipblocklisturl": cfg.IPBlockListURL } {
+		if urlStr == "" {
+			continue
+		}
+		if parsedURL, err := url.Parse(urlStr); err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+			addIssue(key, true, "must be a http or https URL")
+		}
+	}
+
+	if cfg.Listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.Listen); err != nil {
+			addIssue("listen", true, err.Error())
+		}
+	}
+
+	for key, databasePath := range map[string]string { "geoipasndatabase": cfg.GeoIPASNDatabase, "geoipcountrydatabase": cfg.GeoIPCountryDatabase } {
+		if databasePath == "" {
+			continue
+		}
+		if _, err := os.Stat(databasePath); err != nil {
+			addIssue(key, false, err.Error())
+		}
+	}
+
+	// 组合检查.
+	if cfg.ClientType == "Transmission" && cfg.Listen == "" {
+		addIssue("listen", true, "required by Transmission to serve ipfilter")
+	}
+	if cfg.ClientUsername != "" && cfg.ClientPassword == "" {
+		addIssue("clientpassword", false, "empty while clientUsername is set")
+	}
+	if cfg.UseBasicAuth && cfg.ClientUsername == "" {
+		addIssue("usebasicauth", false, "enabled while clientUsername is empty")
+	}
+	if (cfg.Debug_CheckTorrent || cfg.Debug_CheckPeer || cfg.LogDebug) && !cfg.Debug {
+		addIssue("debug", false, "debug_CheckTorrent/debug_CheckPeer/logDebug have no effect while debug is disabled")
+	}
+	if cfg.IPUploadedCheck && cfg.IPUpCheckIncrementMB == 0 && cfg.IPUpCheckPerTorrentRatio <= 0 {
+		addIssue("ipuploadedcheck", false, "enabled while both ipUpCheckIncrementMB and ipUpCheckPerTorrentRatio are 0")
+	}
+	if cfg.CIDRPromotionCount == 1 {
+		addIssue("cidrpromotioncount", false, "every ban will be promoted to IP range")
+	}
+	if cfg.CIDRPromotionCount > 0 && (cfg.BanIPCIDR != "/32" || cfg.BanIP6CIDR != "/128") {
+		addIssue("cidrpromotioncount", false, "banIPCIDR/banIP6CIDR already widen every ban")
+	}
+
+	if !withCompile {
+		return issues
+	}
+
+	// 编译检查.
+	for k, v := range cfg.BlockList {
+		if _, err := regexp.Compile("(?i)" + v); err != nil {
+			addIssue("blocklist[" + strconv.Itoa(k) + "]", true, err.Error())
+		}
+	}
+	for k, v := range cfg.IPBlockList {
+		if ParseIPCIDR(v) == nil {
+			addIssue("ipblocklist[" + strconv.Itoa(k) + "]", true, "bad IP or CIDR")
+		}
+	}
+	for k, v := range cfg.PortBlockList {
+		if _, err := CompilePortBlock(v); err != nil {
+			addIssue("portblocklist[" + strconv.Itoa(k) + "]", true, err.Error())
+		}
+	}
+	for k, v := range cfg.Rules {
+		key := "rules[" + strconv.Itoa(k) + "]"
+		if _, err := CompileRule(v); err != nil {
+			addIssue(key, true, err.Error())
+		}
+		if v.BanIPCIDR != "" && !IsValidCIDRPrefix(v.BanIPCIDR, 32) {
+			addIssue(key + ".banipcidr", true, "must be /0 to /32")
+		}
+		if v.BanIP6CIDR != "" && !IsValidCIDRPrefix(v.BanIP6CIDR, 128) {
+			addIssue(key + ".banip6cidr", true, "must be /0 to /128")
+		}
+	}
+	for k, v := range cfg.TorrentOverrides {
+		key := "torrentoverrides[" + strconv.Itoa(k) + "]"
+		if invalidKeys := GetInvalidTorrentOverrideConfigKeys(v); len(invalidKeys) > 0 {
+			for _, invalidKey := range invalidKeys {
+				addIssue(key + ".config." + strings.ToLower(invalidKey), true, "cannot be overridden per torrent")
+			}
+			continue
+		}
+		if _, err := CompileTorrentOverride(v); err != nil {
+			addIssue(key, true, err.Error())
+		}
+	}
+
+	return issues
+}
+func LogConfigIssues(module string, issues []ConfigIssueStruct, logToFile bool) int {
+	errorCount := 0
+
+	for _, issue := range issues {
+		langKey := "Warning-ConfigIssue"
+		if issue.Error {
+			langKey = "Error-ConfigIssue"
+			errorCount++
+		}
+
+		position := issue.Position
+		if position == "" {
+			position = "-"
+		}
+		key := issue.Key
+		if key == "" {
+			key = "-"
+		}
+
+		Log(module, GetLangText(langKey), logToFile, position, key, issue.Message)
+	}
+
+	return errorCount
+}
+func CheckConfig() bool {
+	// 仅检查配置文件, 不连接客户端; 任一错误均返回 false.
+	// 与运行时相同, 读取配置文件后经 BuildConfig 合并, 以确保检查的配置与实际加载的一致.
+	issues := []ConfigIssueStruct {}
+	configKeyPositionMap = make(map[string]string)
+	configFileContent = nil
+	additionalConfigFileContent = nil
+
+	// 记录合并后, 解析密码前的配置, 用于检查 clientPassword 是否被忽略.
+	rawConfig := ConfigStruct {}
+
+	for _, filename := range []string { configFilename, additionConfigFilename } {
+		configFile, err := os.ReadFile(filename)
+		if err != nil {
+			if filename == additionConfigFilename && os.IsNotExist(err) {
+				continue
+			}
+			issues = append(issues, ConfigIssueStruct { Position: filename, Error: true, Message: err.Error() })
+			continue
+		}
+
+		fileIssues := ValidateConfigKeys(filename, configFile)
+		issues = append(issues, fileIssues...)
+
+		configJSON, err := ConvertConfigToJSON(filename, configFile)
+		if err != nil {
+			continue
+		}
+		if err := json.Unmarshal(configJSON, &rawConfig); err != nil {
+			if len(fileIssues) <= 0 {
+				issues = append(issues, ConfigIssueStruct { Position: filename, Error: true, Message: err.Error() })
+			}
+			continue
+		}
+
+		if filename == configFilename {
+			configFileContent = configJSON
+		} else {
+			additionalConfigFileContent = configJSON
+		}
+	}
+
+	if rawConfig.ClientPasswordFile != "" && rawConfig.ClientPassword != "" {
+		issues = append(issues, ConfigIssueStruct { Position: GetConfigKeyPosition("clientpassword"), Key: "clientpassword", Error: false, Message: "ignored while clientPasswordFile is set" })
+	}
+
+	if checkedConfig, err := BuildConfig(); err != nil {
+		issues = append(issues, ConfigIssueStruct { Error: true, Message: err.Error() })
+	} else {
+		issues = append(issues, ValidateConfigValues(checkedConfig, true)...)
+	}
+
+	errorCount := LogConfigIssues("CheckConfig", issues, false)
+	if errorCount > 0 {
+		Log("CheckConfig", GetLangText("Failed-CheckConfig"), false, errorCount, (len(issues) - errorCount))
+		return false
+	}
+
+	Log("CheckConfig", GetLangText("Success-CheckConfig"), false, len(issues))
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"path/filepath"
+)
+
+func TestCheckConfig(t *testing.T) {
+	lastConfigFilename := configFilename
+	lastAdditionConfigFilename := additionConfigFilename
+	lastConfigFileContent := configFileContent
+	lastAdditionalConfigFileContent := additionalConfigFileContent
+	defer func() {
+		configFilename = lastConfigFilename
+		additionConfigFilename = lastAdditionConfigFilename
+		configFileContent = lastConfigFileContent
+		additionalConfigFileContent = lastAdditionalConfigFileContent
+	}()
+
+	tempDir := t.TempDir()
+	configFilename = filepath.Join(tempDir, "config.json")
+	additionConfigFilename = filepath.Join(tempDir, "config_additional.json")
+
+	testCases := []struct {
+		name       string
+		config     string
+		additional string
+		env        string
+		valid      bool
+	} {
+		{ "valid", `{"interval": 6}`, "", "", true },
+		{ "bad value", `{"interval": 0}`, "", "", false },
+		{ "additional overrides", `{"interval": 6}`, `{"interval": 0}`, "", false },
+		{ "env overrides", `{"interval": 6}`, "", "0", false },
+		{ "unknown key", `{"intervall": 6}`, "", "", false },
+	}
+
+	for _, testCase := range testCases {
+		if err := os.WriteFile(configFilename, []byte(testCase.config), 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		os.Remove(additionConfigFilename)
+		if testCase.additional != "" {
+			if err := os.WriteFile(additionConfigFilename, []byte(testCase.additional), 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+		t.Setenv("QBCB_INTERVAL", testCase.env)
+		if testCase.env == "" {
+			os.Unsetenv("QBCB_INTERVAL")
+		}
+
+		if valid := CheckConfig(); valid != testCase.valid {
+			t.Errorf("%s: CheckConfig = %t, want %t", testCase.name, valid, testCase.valid)
+		}
+	}
+}
+func TestValidateConfigKeysPosition(t *testing.T) {
+	// 各格式均应报告键所在的行.
+	testCases := []struct {
+		filename   string
+		configFile string
+		key        string
+		position   string
+	} {
+		{ "config.json", "{\n\t\"interval\": 6,\n\t\"intervall\": 6\n}", "intervall", "config.json:3" },
+		{ "config.json", "{\n\t\"rules\": [\n\t\t{ \"name\": \"A\" },\n\t\t{ \"name\": \"B\", \"actions\": \"ban\" }\n\t]\n}", "rules[1].actions", "config.json:4" },
+		{ "config.yaml", "interval: 6\n# comment\nintervall: 6\n", "intervall", "config.yaml:3" },
+		{ "config.yaml", "rules:\n  - name: A\n  - name: B\n    actions: ban\n", "rules[1].actions", "config.yaml:4" },
+		{ "config.yaml", "interval: \"6\"\n", "interval", "config.yaml:1" },
+		{ "config.toml", "interval = 6\n# comment\nintervall = 6\n", "intervall", "config.toml:3" },
+		{ "config.toml", "interval = 6\n\n[[rules]]\nname = \"A\"\n\n[[rules]]\nname = \"B\"\nactions = \"ban\"\n", "rules[1].actions", "config.toml:8" },
+	}
+
+	for _, testCase := range testCases {
+		issues := ValidateConfigKeys(testCase.filename, []byte(testCase.configFile))
+		if len(issues) != 1 || issues[0].Key != testCase.key || issues[0].Position != testCase.position {
+			t.Errorf("ValidateConfigKeys(%s, %q) = %+v, want %s at %s", testCase.filename, testCase.configFile, issues, testCase.key, testCase.position)
+		}
+	}
+}